@@ -0,0 +1,117 @@
+// Package causal delivers broadcasts in causal order using vector clocks.
+package causal
+
+import (
+	"program/clock"
+	"sync"
+)
+
+// Message is a broadcast waiting for causal delivery. Clock is the sender's
+// vector clock at the time of sending.
+type Message struct {
+	Sender int64
+	Clock  clock.Vector
+	Value  interface{}
+}
+
+// HoldBack buffers messages until every message they causally depend on has
+// been delivered. It is safe for concurrent use.
+type HoldBack struct {
+	mu        sync.Mutex
+	self      int64
+	delivered clock.Vector
+	pending   []Message
+}
+
+// NewHoldBack makes an empty queue for the client with the given id.
+func NewHoldBack(self int64) *HoldBack {
+	return &HoldBack{self: self, delivered: make(clock.Vector)}
+}
+
+// NewHoldBackFrom makes an empty queue for a client that joins once the messages
+// counted in baseline were sent, so it doesn't wait for them.
+func NewHoldBackFrom(self int64, baseline clock.Vector) *HoldBack {
+	h := NewHoldBack(self)
+	h.delivered.Merge(baseline)
+	return h
+}
+
+// Send records a local broadcast and returns the vector clock to attach to it.
+func (h *HoldBack) Send() clock.Vector {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.delivered[h.self]++
+	return h.delivered.Copy()
+}
+
+// Add queues m and returns every message that has become deliverable, in the
+// order they must be delivered. Messages that were already delivered are dropped.
+func (h *HoldBack) Add(m Message) []Message {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if m.Clock[m.Sender] <= h.delivered[m.Sender] {
+		return nil
+	}
+	h.pending = append(h.pending, m)
+
+	var ready []Message
+	for progress := true; progress; {
+		progress = false
+		for i := 0; i < len(h.pending); i++ {
+			p := h.pending[i]
+			stale := p.Clock[p.Sender] <= h.delivered[p.Sender]
+			if !stale && !h.deliverable(p) {
+				continue
+			}
+			h.pending = append(h.pending[:i], h.pending[i+1:]...)
+			i--
+			if stale {
+				continue
+			}
+			h.delivered[p.Sender] = p.Clock[p.Sender]
+			ready = append(ready, p)
+			progress = true
+		}
+	}
+	return ready
+}
+
+// Restart records that sender started a new session, counting on from t. When that
+// is behind what was delivered from it, it lost its clock and its old messages are forgotten.
+func (h *HoldBack) Restart(sender int64, t uint64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t < h.delivered[sender] {
+		h.delivered[sender] = t
+	}
+}
+
+// deliverable reports whether m is the next message from its sender and
+// everything the sender had seen before sending it has been delivered here.
+func (h *HoldBack) deliverable(m Message) bool {
+	for id, t := range m.Clock {
+		if id == m.Sender {
+			if t != h.delivered[id]+1 {
+				return false
+			}
+		} else if t > h.delivered[id] {
+			return false
+		}
+	}
+	return true
+}
+
+// Pending returns how many messages are being held back.
+func (h *HoldBack) Pending() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.pending)
+}
+
+// Delivered returns a copy of the vector clock of delivered messages.
+func (h *HoldBack) Delivered() clock.Vector {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.delivered.Copy()
+}
@@ -0,0 +1,133 @@
+package causal
+
+import (
+	"program/clock"
+	"reflect"
+	"testing"
+)
+
+func msg(sender int64, vector clock.Vector, value string) Message {
+	return Message{Sender: sender, Clock: vector, Value: value}
+}
+
+func values(msgs []Message) []string {
+	out := []string{}
+	for _, m := range msgs {
+		out = append(out, m.Value.(string))
+	}
+	return out
+}
+
+func TestReplyWaitsForWhatItAnswers(t *testing.T) {
+	h := NewHoldBack(3)
+	question := msg(1, clock.Vector{1: 1}, "question")
+	answer := msg(2, clock.Vector{1: 1, 2: 1}, "answer")
+
+	if got := h.Add(answer); len(got) != 0 {
+		t.Fatalf("delivered %v before the question it answers", values(got))
+	}
+	if h.Pending() != 1 {
+		t.Fatalf("pending = %d, want 1", h.Pending())
+	}
+	if got := values(h.Add(question)); !reflect.DeepEqual(got, []string{"question", "answer"}) {
+		t.Fatalf("delivered %v, want question then answer", got)
+	}
+	if h.Pending() != 0 {
+		t.Fatalf("pending = %d after everything arrived", h.Pending())
+	}
+}
+
+func TestSenderOrderIsKept(t *testing.T) {
+	h := NewHoldBack(9)
+	first := msg(1, clock.Vector{1: 1}, "first")
+	second := msg(1, clock.Vector{1: 2}, "second")
+	third := msg(1, clock.Vector{1: 3}, "third")
+
+	if got := h.Add(third); len(got) != 0 {
+		t.Fatalf("delivered %v out of order", values(got))
+	}
+	if got := h.Add(second); len(got) != 0 {
+		t.Fatalf("delivered %v out of order", values(got))
+	}
+	if got := values(h.Add(first)); !reflect.DeepEqual(got, []string{"first", "second", "third"}) {
+		t.Fatalf("delivered %v, want all three in order", got)
+	}
+	if want := (clock.Vector{1: 3}); !reflect.DeepEqual(h.Delivered(), want) {
+		t.Fatalf("delivered clock = %v, want %v", h.Delivered(), want)
+	}
+}
+
+func TestConcurrentMessagesAreNotHeld(t *testing.T) {
+	h := NewHoldBack(9)
+	if got := values(h.Add(msg(2, clock.Vector{2: 1}, "from 2"))); !reflect.DeepEqual(got, []string{"from 2"}) {
+		t.Fatalf("delivered %v", got)
+	}
+	if got := values(h.Add(msg(1, clock.Vector{1: 1}, "from 1"))); !reflect.DeepEqual(got, []string{"from 1"}) {
+		t.Fatalf("delivered %v", got)
+	}
+}
+
+func TestDuplicatesAreDropped(t *testing.T) {
+	h := NewHoldBack(9)
+	m := msg(1, clock.Vector{1: 1}, "once")
+	h.Add(m)
+	if got := h.Add(m); len(got) != 0 {
+		t.Fatalf("delivered %v twice", values(got))
+	}
+	//A copy that arrives again while its successor is held back is dropped too
+	h.Add(msg(1, clock.Vector{1: 3}, "later"))
+	h.Add(m)
+	if h.Pending() != 1 {
+		t.Fatalf("pending = %d, want only the message that is ahead", h.Pending())
+	}
+}
+
+func TestSendCountsOwnMessages(t *testing.T) {
+	h := NewHoldBack(1)
+	if v := h.Send(); v[1] != 1 {
+		t.Fatalf("first send stamped %v", v)
+	}
+	sent := h.Send()
+	if sent[1] != 2 {
+		t.Fatalf("second send stamped %v", sent)
+	}
+	//A reply to our second message from someone else is delivered straight away
+	reply := msg(2, clock.Vector{1: 2, 2: 1}, "reply")
+	if got := values(h.Add(reply)); !reflect.DeepEqual(got, []string{"reply"}) {
+		t.Fatalf("delivered %v", got)
+	}
+}
+
+func TestLateJoinerStartsFromTheBaseline(t *testing.T) {
+	//Clients 1 and 2 had been talking for a while before 3 joined
+	h := NewHoldBackFrom(3, clock.Vector{1: 5, 2: 2})
+	reply := msg(2, clock.Vector{1: 5, 2: 3}, "reply")
+	if got := values(h.Add(reply)); !reflect.DeepEqual(got, []string{"reply"}) {
+		t.Fatalf("delivered %v, want the reply straight away", got)
+	}
+	next := msg(1, clock.Vector{1: 6, 2: 3}, "next")
+	if got := values(h.Add(next)); !reflect.DeepEqual(got, []string{"next"}) {
+		t.Fatalf("delivered %v, want the next message straight away", got)
+	}
+	//Our own count carries on from the baseline too
+	if v := h.Send(); v[3] != 1 || v[1] != 6 {
+		t.Fatalf("first send stamped %v", v)
+	}
+}
+
+func TestRestartedSenderCountsAgain(t *testing.T) {
+	h := NewHoldBack(9)
+	h.Add(msg(1, clock.Vector{1: 1}, "before"))
+	h.Add(msg(1, clock.Vector{1: 2}, "before"))
+	//Joining again where it left off changes nothing
+	h.Restart(1, 2)
+	if got := h.Add(msg(1, clock.Vector{1: 2}, "before")); len(got) != 0 {
+		t.Fatalf("delivered %v twice", values(got))
+	}
+
+	//Client 1 lost its clock and starts over
+	h.Restart(1, 0)
+	if got := values(h.Add(msg(1, clock.Vector{1: 1}, "after"))); !reflect.DeepEqual(got, []string{"after"}) {
+		t.Fatalf("delivered %v, want the new session's first message", got)
+	}
+}
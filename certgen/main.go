@@ -0,0 +1,57 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"program/certs"
+	"strings"
+)
+
+// certgen writes a throwaway CA, a server certificate and one client certificate
+// per name, for trying TLS and mutual TLS out locally:
+//
+//	go run ./certgen -out tls -clients alice,bob
+//	go run ./server -tls-cert tls/server.pem -tls-key tls/server-key.pem -tls-ca tls/ca.pem -require-client-cert
+//	go run ./client -tls-cert tls/alice.pem -tls-key tls/alice-key.pem -tls-ca tls/ca.pem
+func main() {
+	out := flag.String("out", "tls", "Directory to write the certificates to")
+	hosts := flag.String("hosts", "localhost,127.0.0.1", "Comma separated host names and addresses the server certificate is valid for")
+	clients := flag.String("clients", "", "Comma separated names to issue client certificates for")
+	flag.Parse()
+
+	if err := os.MkdirAll(*out, 0755); err != nil {
+		log.Fatalf("could not create %s: %v", *out, err)
+	}
+
+	ca, err := certs.NewCA("Route test CA")
+	if err != nil {
+		log.Fatalf("could not make CA: %v", err)
+	}
+	if err := ca.Write(filepath.Join(*out, "ca.pem"), filepath.Join(*out, "ca-key.pem")); err != nil {
+		log.Fatalf("could not write CA: %v", err)
+	}
+
+	server, err := ca.Server("localhost", strings.Split(*hosts, ",")...)
+	if err != nil {
+		log.Fatalf("could not make server certificate: %v", err)
+	}
+	if err := server.Write(filepath.Join(*out, "server.pem"), filepath.Join(*out, "server-key.pem")); err != nil {
+		log.Fatalf("could not write server certificate: %v", err)
+	}
+
+	for _, name := range strings.Split(*clients, ",") {
+		if name == "" {
+			continue
+		}
+		client, err := ca.Client(name)
+		if err != nil {
+			log.Fatalf("could not make certificate for %s: %v", name, err)
+		}
+		if err := client.Write(filepath.Join(*out, name+".pem"), filepath.Join(*out, name+"-key.pem")); err != nil {
+			log.Fatalf("could not write certificate for %s: %v", name, err)
+		}
+	}
+	log.Println("Certificates written to " + *out)
+}
@@ -0,0 +1,70 @@
+// Package certs loads the TLS settings of the server and client, and makes
+// throwaway certificate authorities for trying TLS out locally.
+package certs
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+)
+
+// ServerConfig loads the server's key pair. With a CA file, client certificates
+// signed by it are verified, and required when requireClientCert is set.
+func ServerConfig(certFile, keyFile, caFile string, requireClientCert bool) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	if caFile == "" {
+		if requireClientCert {
+			return nil, errors.New("certs: requiring client certificates needs a CA to check them against")
+		}
+		return config, nil
+	}
+
+	pool, err := loadPool(caFile)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = pool
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// ClientConfig trusts the server certificates signed by the CA, or the system's
+// roots when caFile is empty, and presents the client's key pair if one is given.
+func ClientConfig(certFile, keyFile, caFile string) (*tls.Config, error) {
+	config := &tls.Config{MinVersion: tls.VersionTLS12}
+	if caFile != "" {
+		pool, err := loadPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = pool
+	}
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+func loadPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, errors.New("certs: no certificates in " + caFile)
+	}
+	return pool, nil
+}
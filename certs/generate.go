@@ -0,0 +1,148 @@
+package certs
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"time"
+)
+
+// How long generated certificates stay valid
+const validFor = 30 * 24 * time.Hour
+
+// CA is a certificate authority that signs leaf certificates. It is meant for
+// local testing only, its key never leaves memory unless written out.
+type CA struct {
+	Cert *x509.Certificate
+	Key  *ecdsa.PrivateKey
+	// PEM encodings of Cert and Key
+	CertPEM, KeyPEM []byte
+}
+
+// Leaf is a certificate signed by a CA, with its key
+type Leaf struct {
+	CertPEM, KeyPEM []byte
+}
+
+// NewCA makes a self-signed certificate authority
+func NewCA(commonName string) (*CA, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	template.IsCA = true
+	template.BasicConstraintsValid = true
+	template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &CA{Cert: cert, Key: key, CertPEM: encodeCert(der), KeyPEM: keyPEM}, nil
+}
+
+// Server issues a server certificate for the given host names and addresses
+func (ca *CA) Server(commonName string, hosts ...string) (*Leaf, error) {
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	//The servers of a cluster also present it to each other as clients
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, host)
+		}
+	}
+	return ca.issue(template)
+}
+
+// Client issues a client certificate. The server uses commonName as the client's name.
+func (ca *CA) Client(commonName string) (*Leaf, error) {
+	template, err := newTemplate(commonName)
+	if err != nil {
+		return nil, err
+	}
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	return ca.issue(template)
+}
+
+func (ca *CA) issue(template *x509.Certificate) (*Leaf, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Cert, &key.PublicKey, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := encodeKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Leaf{CertPEM: encodeCert(der), KeyPEM: keyPEM}, nil
+}
+
+// Write saves the certificate and key as PEM files, the key readable only by its owner
+func (l *Leaf) Write(certFile, keyFile string) error {
+	return writePair(l.CertPEM, l.KeyPEM, certFile, keyFile)
+}
+
+// Write saves the CA's certificate and key as PEM files
+func (ca *CA) Write(certFile, keyFile string) error {
+	return writePair(ca.CertPEM, ca.KeyPEM, certFile, keyFile)
+}
+
+func writePair(certPEM, keyPEM []byte, certFile, keyFile string) error {
+	if err := ioutil.WriteFile(certFile, certPEM, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(keyFile, keyPEM, 0600)
+}
+
+func newTemplate(commonName string) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    now.Add(-time.Hour),
+		NotAfter:     now.Add(validFor),
+	}, nil
+}
+
+func encodeCert(der []byte) []byte {
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func encodeKey(key *ecdsa.PrivateKey) ([]byte, error) {
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), nil
+}
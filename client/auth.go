@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"sync"
+)
+
+// sessionToken attaches the token Connect handed out to every call after it
+type sessionToken struct {
+	mu    sync.Mutex
+	token string
+}
+
+func (t *sessionToken) set(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.token = token
+}
+
+func (t *sessionToken) get() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.token
+}
+
+func (t *sessionToken) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.token == "" {
+		return nil, nil
+	}
+	return map[string]string{"route-token": t.token}, nil
+}
+
+// The token travels in the clear on insecure connections, like everything else
+func (t *sessionToken) RequireTransportSecurity() bool {
+	return false
+}
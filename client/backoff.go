@@ -0,0 +1,28 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff spaces out reconnect attempts, doubling the wait each time up to max.
+// Waits are jittered so clients that lost the same server don't all come back at once.
+type backoff struct {
+	base, max time.Duration
+	attempt   int
+}
+
+// next returns how long to wait before the next attempt, somewhere in the upper half of the current step
+func (b *backoff) next() time.Duration {
+	step := b.base << uint(b.attempt)
+	if step > b.max || step <= 0 {
+		step = b.max
+	} else {
+		b.attempt++
+	}
+	return step/2 + time.Duration(rand.Int63n(int64(step/2)+1))
+}
+
+func (b *backoff) reset() {
+	b.attempt = 0
+}
@@ -6,20 +6,66 @@ import (
 	"flag"
 	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"program/causal"
+	"program/certs"
+	"program/clock"
 	pb "program/route"
+	"strconv"
+	"strings"
+	"syscall"
 	"time"
 )
 
 func main() {
 
 	//Get client ID
-	id := flag.Int64("id", 0, "current environment")
+	id := flag.Int64("id", 0, "Client id, 0 lets the server pick one")
+	name := flag.String("name", "", "Display name, empty lets the server pick one")
+	status := flag.String("status", "", "Status shown to other clients")
+	causalOrder := flag.Bool("causal", false, "Hold back broadcasts until their causal predecessors are delivered")
+	history := flag.Int("history", defaultHistory, "How many old messages to show when joining")
+	tlsCert := flag.String("tls-cert", "", "Client certificate for mutual TLS, the server takes the name from it")
+	tlsKey := flag.String("tls-key", "", "Key of the client certificate")
+	tlsCA := flag.String("tls-ca", "", "CA that signed the server certificate, setting it turns on TLS")
+	address := flag.String("server", "localhost:5000", "Address of the server")
+	servers := flag.String("servers", "", "Comma separated addresses of the servers of a cluster, used instead of -server. Calls follow the leader and move on from servers that are down")
+	user := flag.String("user", "", "User name to log in with, also the default display name")
+	passwordFile := flag.String("password-file", "", "File holding the password or pre-shared key to log in with")
+	maxBackoff := flag.Duration("max-backoff", 10*time.Second, "Longest wait between reconnect attempts")
 	flag.Parse()
+	retry := &backoff{base: 250 * time.Millisecond, max: *maxBackoff}
 
-	//Set up connection
-	conn, err := grpc.Dial("localhost:5000", grpc.WithInsecure(), grpc.WithBlock())
+	//Set up connection, gRPC dials again by itself whenever the connection drops
+	token := &sessionToken{}
+	transport := grpc.WithInsecure()
+	if *tlsCA != "" || *tlsCert != "" {
+		config, err := certs.ClientConfig(*tlsCert, *tlsKey, *tlsCA)
+		if err != nil {
+			log.Fatalf("could not load certificates: %v", err)
+		}
+		transport = grpc.WithTransportCredentials(credentials.NewTLS(config))
+	}
+	addrs := []string{*address}
+	if *servers != "" {
+		addrs = nil
+		for _, addr := range strings.Split(*servers, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	if len(addrs) == 0 {
+		log.Fatalf("no server to connect to")
+	}
+	conn, err := newFailover(addrs, transport, grpc.WithPerRPCCredentials(token))
 	if err != nil {
 		log.Fatalf("did not connect: %v", err)
 	}
@@ -27,35 +73,363 @@ func main() {
 	defer conn.Close()
 	client := pb.NewRouteClient(conn)
 
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-	defer cancel()
+	password := ""
+	if *passwordFile != "" {
+		data, err := ioutil.ReadFile(*passwordFile)
+		if err != nil {
+			log.Fatalf("could not read password: %v", err)
+		}
+		password = strings.TrimSpace(string(data))
+	}
 
 	//Connect to server
-	ack, err := client.Connect(ctx, &pb.ConnectRequest{Id: *id})
+	login := &pb.ConnectRequest{Id: *id, Name: *name, Status: *status, User: *user, Password: password}
+	ack := connect(client, login, retry)
+	log.Println(ack.Status)
+	token.set(ack.Token)
+	me := ack.Client
+
+	if *causalOrder {
+		//Whatever was said before we came is not waited for
+		holdBack = causal.NewHoldBackFrom(me.Id, ack.Vector)
+	}
+
+	//Open one long-lived chat session and introduce ourselves on it
+	stream, err := openChat(client, me)
 	if err != nil {
-		log.Fatalf("could not greet: %v", err)
+		log.Fatalf("could not open chat: %v", err)
 	}
-	log.Println(ack.Status)
+	//Keep our membership lease alive
+	if ack.LeaseTtlMs > 0 {
+		//It only needs our id, and /nick changes the name while it runs
+		go heartbeat(client, &pb.Client{Id: me.Id}, time.Duration(ack.LeaseTtlMs)*time.Millisecond/3)
+	}
+
+	if *history > 0 {
+		showHistory(client, me, *history)
+	}
+
+	t := &transcript{client: client, me: me}
+	received := make(chan error, 1)
+	go receive(stream, t, received)
+
+	//While the session is down stream is nil and typed lines wait in queue
+	var queue []*pb.RequestText
+	reconnected := make(chan pb.Route_ChatClient)
+
+	//Leave properly on Ctrl-C
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
 
 	//Ask forever
+	lines := make(chan string)
+	go readLines(lines)
+	for {
+		select {
+		case text, ok := <-lines:
+			//Stdin closed
+			if !ok {
+				leave(client, stream, me)
+				return
+			}
+			if strings.HasPrefix(text, "/") {
+				command(client, me, login, text)
+				continue
+			}
+			out := &pb.RequestText{Body: text, Client: me, Room: currentRoom, RequestId: requestID(me)}
+			//Only the lobby reaches everyone, a vector counting room messages would hold the
+			//lobby back for those who never get them
+			if holdBack != nil && currentRoom == "" {
+				out.Vector = holdBack.Send()
+			}
+			if stream == nil {
+				queue = append(queue, out)
+				fmt.Println("Not connected, the message is sent once the connection is back")
+				continue
+			}
+			//A failed send means the stream broke, receive reports it and we reconnect from there
+			if err := send(stream, out); err != nil {
+				queue = append(queue, out)
+				stream = nil
+				continue
+			}
+			sent.add(out)
+		case <-sigs:
+			fmt.Println()
+			leave(client, stream, me)
+			return
+		case err := <-received:
+			stream = nil
+			switch {
+			case reason(err) == pb.ErrorReason_SHUTTING_DOWN:
+				log.Println("The server is shutting down, reconnecting")
+			case reason(err) == pb.ErrorReason_NOT_LEADER:
+				log.Println("The server is not the leader, following it")
+			default:
+				log.Printf("lost chat session: %v, reconnecting", err)
+			}
+			//Whatever we haven't seen come back may be lost, so it goes again once we are
+			//back. It keeps its request id, so the server drops what it already broadcast
+			queue = append(sent.take(), queue...)
+			go reconnect(client, login, me, joinedRooms(), token, t, retry, reconnected)
+		case stream = <-reconnected:
+			go receive(stream, t, received)
+			for len(queue) > 0 {
+				if err := send(stream, queue[0]); err != nil {
+					stream = nil
+					break
+				}
+				sent.add(queue[0])
+				queue = queue[1:]
+			}
+		}
+	}
+
+}
+
+// connect joins the server, asking for another id or name while the chosen one is
+// taken and waiting for the server if it isn't up yet
+func connect(client pb.RouteClient, in *pb.ConnectRequest, retry *backoff) *pb.Acknowledgement {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		ack, err := client.Connect(ctx, in)
+		cancel()
+		if err == nil {
+			retry.reset()
+			return ack
+		}
+		if code := status.Code(err); code == codes.Unavailable || code == codes.DeadlineExceeded || reason(err) == pb.ErrorReason_NOT_LEADER {
+			wait := retry.next()
+			log.Printf("server not reachable, trying again in %v", wait.Round(time.Millisecond))
+			time.Sleep(wait)
+			continue
+		}
+
+		switch reason(err) {
+		case pb.ErrorReason_DUPLICATE_CLIENT:
+			fmt.Printf("Client id %d is taken, pick another: ", in.Id)
+			if next, err := strconv.ParseInt(prompt(), 10, 64); err == nil {
+				in.Id = next
+			}
+		case pb.ErrorReason_NAME_TAKEN, pb.ErrorReason_INVALID_NAME:
+			fmt.Printf("The name %q can't be used, pick another: ", in.Name)
+			in.Name = prompt()
+		default:
+			log.Fatalf("could not greet: %v", err)
+		}
+	}
+}
+
+// prompt reads one answer from stdin
+func prompt() string {
+	text, _, err := stdin.ReadLine()
+	if err != nil {
+		log.Fatalf("could not greet: %v", err)
+	}
+	return strings.TrimSpace(string(text))
+}
+
+// stdin is shared by everything that reads what the user types
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLines feeds stdin to lines one line at a time and closes it on EOF
+func readLines(lines chan<- string) {
 	for {
 		//Get text from input
-		reader := bufio.NewReader(os.Stdin)
 		fmt.Print("Enter text: ")
-		text, _, _ := reader.ReadLine()
+		text, _, err := stdin.ReadLine()
+		if err != nil {
+			close(lines)
+			return
+		}
+		lines <- string(text)
+	}
+}
+
+// heartbeat renews the client's lease every interval for as long as the program runs
+func heartbeat(client pb.RouteClient, me *pb.Client, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := client.Heartbeat(ctx, me)
+		cancel()
+		if err != nil {
+			log.Printf("could not send heartbeat: %v", err)
+		}
+	}
+}
 
-		//Send request and wait for reply
-		//New context for every loop
+// openChat starts a chat stream and introduces the client on it
+func openChat(client pb.RouteClient, me *pb.Client) (pb.Route_ChatClient, error) {
+	stream, err := client.Chat(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	if err := send(stream, &pb.RequestText{Client: me}); err != nil {
+		return nil, err
+	}
+	return stream, nil
+}
+
+// reconnect brings the session back after the stream broke, resuming it with the
+// token when the server still knows it and connecting again as the same client when
+// not, joining its rooms again in that case. It shows what was broadcast in the
+// meantime, then hands over the new stream.
+func reconnect(client pb.RouteClient, login *pb.ConnectRequest, me *pb.Client, rooms []string, token *sessionToken, t *transcript, retry *backoff, done chan<- pb.Route_ChatClient) {
+	in := proto.Clone(login).(*pb.ConnectRequest)
+	in.Id = me.Id
+	if in.Name == "" {
+		in.Name = me.Name
+	}
+	for {
+		time.Sleep(retry.next())
+
+		in.Token = token.get()
 		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-		defer cancel()
+		ack, err := client.Connect(ctx, in)
+		cancel()
+		if err != nil {
+			switch reason(err) {
+			case pb.ErrorReason_NAME_TAKEN:
+				//Someone took the name while we were gone, take whatever the server gives us
+				in.Name = ""
+			case pb.ErrorReason_DUPLICATE_CLIENT, pb.ErrorReason_AUTHENTICATION_FAILED, pb.ErrorReason_IDENTITY_MISMATCH:
+				log.Fatalf("could not reconnect: %v", err)
+			}
+			continue
+		}
+
+		token.set(ack.Token)
+		//A resumed session keeps its token and everything that goes with it, a new one starts out in no room
+		if ack.Token != in.Token {
+			rejoin(client, me, rooms)
+		}
+		stream, err := openChat(client, me)
+		if err != nil {
+			continue
+		}
+		for _, msg := range t.replay(ack.LastSeq) {
+			deliver(msg, me)
+		}
+		retry.reset()
+		log.Println(ack.Status)
+		done <- stream
+		return
+	}
+}
+
+// rejoin joins the rooms again after the server forgot the client
+func rejoin(client pb.RouteClient, me *pb.Client, rooms []string) {
+	for _, room := range rooms {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		_, err := client.JoinRoom(ctx, &pb.RoomRequest{Client: me, Room: room})
+		cancel()
+		if err != nil {
+			log.Printf("could not join %s again: %v", room, err)
+		}
+	}
+}
+
+// leave tells the server we are going and ends the chat session, if there is one
+func leave(client pb.RouteClient, stream pb.Route_ChatClient, me *pb.Client) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	ack, err := client.Disconnect(ctx, me)
+	if err != nil {
+		log.Printf("could not disconnect: %v", err)
+	} else {
+		log.Println(ack.Status)
+	}
+	if stream != nil {
+		stream.CloseSend()
+	}
+}
+
+// lamport is the client's logical clock, ticked on every send and merged on every receive
+var lamport clock.Lamport
+
+// holdBack orders incoming broadcasts causally, it is nil unless -causal is set
+var holdBack *causal.HoldBack
+
+// sent is the text that went out on the chat stream and hasn't come back yet
+var sent outbox
 
-		reply, err := client.SayHello(ctx, &pb.RequestText{Body: string(text), Client: &pb.Client{Id: *id}})
+// requestSeq numbers the messages we send, a message sent again keeps its number.
+// Numbering starts from when the client started, so a client that restarts with the
+// same id doesn't reuse numbers the server still remembers from its last run.
+var requestSeq = uint64(time.Now().UnixNano())
+
+// requestID gives a message its own request id, so the server can tell a retry from a new message
+func requestID(me *pb.Client) *pb.RequestId {
+	requestSeq++
+	return &pb.RequestId{Client: me.Id, Seq: requestSeq}
+}
+
+func send(stream pb.Route_ChatClient, text *pb.RequestText) error {
+	text.Lamport = lamport.Tick()
+	return stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Send{Send: text}})
+}
+
+// receive prints every event the server pushes until the stream ends
+func receive(stream pb.Route_ChatClient, t *transcript, done chan<- error) {
+	for {
+		in, err := stream.Recv()
 		if err != nil {
-			log.Fatalf("could not say hello: %v", err)
+			done <- err
+			return
+		}
+		for _, msg := range t.accept(in.GetEvent()) {
+			deliver(msg, t.me)
 		}
+	}
+}
+
+// deliver shows msg once everything it causally depends on has been shown, me being who we are
+func deliver(msg *pb.GenericText, me *pb.Client) {
+	lamport.Witness(msg.GetLamport())
+	if msg.Client.GetId() == me.GetId() && msg.RequestId != nil {
+		sent.arrived(msg.RequestId)
+	}
 
-		//Print reply
-		log.Printf(reply.GetBody())
+	//Messages from clients that are not in causal mode carry no vector and are shown right away,
+	//as are those to rooms, which not everyone gets. Our own were counted as delivered when we
+	//sent them, so the hold-back queue would take them for old news
+	if holdBack == nil || len(msg.Vector) == 0 || msg.Room != "" || msg.Recipient != nil || msg.Client.GetId() == me.GetId() {
+		show(msg)
+		return
 	}
+	//Someone joining says where their count goes on from, in case it started over
+	if msg.Kind == pb.EventKind_JOIN {
+		show(msg)
+		holdBack.Restart(msg.Client.GetId(), msg.Vector[msg.Client.GetId()])
+		return
+	}
+	for _, ready := range holdBack.Add(causal.Message{Sender: msg.Client.GetId(), Clock: msg.Vector, Value: msg}) {
+		show(ready.Value.(*pb.GenericText))
+	}
+}
 
+func show(msg *pb.GenericText) {
+	if msg.GetKind() == pb.EventKind_MESSAGE {
+		where := ""
+		if msg.GetRoom() != "" {
+			where = " #" + msg.GetRoom()
+		}
+		if msg.GetRecipient() != nil {
+			where = " (private to " + displayName(msg.Recipient) + ")"
+		}
+		fmt.Printf("\n#%d [%d]%s %s: %s\nEnter text: ", msg.GetSeq(), msg.GetLamport(), where, displayName(msg.Client), msg.GetBody())
+	} else {
+		fmt.Printf("\n#%d [%d] * %s\nEnter text: ", msg.GetSeq(), msg.GetLamport(), msg.GetBody())
+	}
+}
+
+// displayName is the client's name, or its id when the server sent none
+func displayName(c *pb.Client) string {
+	if c.GetName() != "" {
+		return c.GetName()
+	}
+	return fmt.Sprintf("Client %d", c.GetId())
 }
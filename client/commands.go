@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	pb "program/route"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How many old messages /history shows when no count is given
+const defaultHistory = 10
+
+// currentRoom is where typed lines go, empty for the lobby
+var currentRoom string
+
+// joined holds the rooms we are in, to join them again if the server forgets us
+var joined = make(map[string]bool)
+
+// joinedRooms lists the rooms we are in
+func joinedRooms() []string {
+	var rooms []string
+	for room := range joined {
+		rooms = append(rooms, room)
+	}
+	return rooms
+}
+
+// command runs a line starting with a slash. login is what we connected with, for
+// connecting again under the name we have now
+func command(client pb.RouteClient, me *pb.Client, login *pb.ConnectRequest, line string) {
+	fields := strings.Fields(line)
+	switch fields[0] {
+	case "/history":
+		n := defaultHistory
+		if len(fields) > 1 {
+			var err error
+			if n, err = strconv.Atoi(fields[1]); err != nil || n < 1 {
+				fmt.Println("usage: /history [n]")
+				return
+			}
+		}
+		showHistory(client, me, n)
+	case "/join":
+		if len(fields) != 2 {
+			fmt.Println("usage: /join <room>")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ack, err := client.JoinRoom(ctx, &pb.RoomRequest{Client: me, Room: fields[1]})
+		if err != nil {
+			fmt.Printf("could not join %s: %v\n", fields[1], err)
+			return
+		}
+		currentRoom = fields[1]
+		joined[fields[1]] = true
+		fmt.Println(ack.Status)
+	case "/leave":
+		if len(fields) != 2 {
+			fmt.Println("usage: /leave <room>")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ack, err := client.LeaveRoom(ctx, &pb.RoomRequest{Client: me, Room: fields[1]})
+		if err != nil {
+			fmt.Printf("could not leave %s: %v\n", fields[1], err)
+			return
+		}
+		delete(joined, fields[1])
+		if currentRoom == fields[1] {
+			currentRoom = ""
+		}
+		fmt.Println(ack.Status)
+	case "/msg":
+		parts := strings.SplitN(line, " ", 3)
+		if len(parts) != 3 {
+			fmt.Println("usage: /msg <id> <text>")
+			return
+		}
+		to, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			fmt.Println("usage: /msg <id> <text>")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		_, err = client.SendDirect(ctx, &pb.RequestText{Body: parts[2], Client: me, Recipient: &pb.Client{Id: to}, Lamport: lamport.Tick(), RequestId: requestID(me)})
+		if reason(err) == pb.ErrorReason_RECIPIENT_NOT_FOUND {
+			fmt.Printf("Client %d is not connected\n", to)
+		} else if err != nil {
+			fmt.Printf("could not send to %d: %v\n", to, err)
+		}
+	case "/nick":
+		name := strings.TrimSpace(strings.TrimPrefix(line, "/nick"))
+		if name == "" {
+			fmt.Println("usage: /nick <name>")
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		ack, err := client.Rename(ctx, &pb.RenameRequest{Client: me, Name: name})
+		if reason(err) == pb.ErrorReason_NAME_TAKEN {
+			fmt.Printf("The name %s is taken\n", name)
+			return
+		} else if err != nil {
+			fmt.Printf("could not rename: %v\n", err)
+			return
+		}
+		//Keep the name the server settled on, so a reconnect doesn't ask for the old one
+		me.Name = ack.Client.GetName()
+		login.Name = me.Name
+		fmt.Println(ack.Status)
+	case "/rooms":
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		list, err := client.ListRooms(ctx, me)
+		if err != nil {
+			fmt.Printf("could not list rooms: %v\n", err)
+			return
+		}
+		for _, room := range list.Rooms {
+			fmt.Printf("#%s %v\n", room.Name, room.Members)
+		}
+	default:
+		fmt.Println("Unknown command " + fields[0])
+	}
+}
+
+// showHistory prints the last n messages sent to the current room before now, oldest first
+func showHistory(client pb.RouteClient, me *pb.Client, n int) {
+	var msgs []*pb.GenericText
+	cursor := ""
+	for len(msgs) < n {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		page, err := client.History(ctx, &pb.HistoryRequest{Client: me, Room: currentRoom, Cursor: cursor, PageSize: int32(n - len(msgs))})
+		cancel()
+		if err != nil {
+			fmt.Printf("could not read history: %v\n", err)
+			return
+		}
+
+		//Pages come newest first
+		msgs = append(page.Messages, msgs...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	fmt.Printf("--- last %d messages ---", len(msgs))
+	for _, msg := range msgs {
+		lamport.Witness(msg.GetLamport())
+		show(msg)
+	}
+	fmt.Println()
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/status"
+	pb "program/route"
+)
+
+// info returns what the server attached to err about why it failed, if anything
+func info(err error) *errdetails.ErrorInfo {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == "route" {
+			return info
+		}
+	}
+	return nil
+}
+
+// reason returns the ErrorReason the server attached to err, if any
+func reason(err error) pb.ErrorReason {
+	if info := info(err); info != nil {
+		return pb.ErrorReason(pb.ErrorReason_value[info.Reason])
+	}
+	return pb.ErrorReason_ERROR_REASON_UNSPECIFIED
+}
+
+// leader returns the address of the leader a follower sent us on to, if it knows one
+func leader(err error) string {
+	if info := info(err); info != nil {
+		return info.Metadata["leader"]
+	}
+	return ""
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"log"
+	pb "program/route"
+	"sync"
+	"time"
+)
+
+// failover spreads the client over the servers of a cluster. Calls go to one
+// server until it turns them away: a follower that names the leader sends them
+// there, and a server that is down or shutting down moves them on to the next.
+// It stands in for a single connection under pb.NewRouteClient.
+type failover struct {
+	opts  []grpc.DialOption
+	mu    sync.Mutex
+	addrs []string
+	conns map[string]*grpc.ClientConn
+	//Index into addrs of the server calls go to
+	current int
+	//When each server last failed a call
+	failed map[string]time.Time
+}
+
+// newFailover connects to addrs with opts, starting with the first of them
+func newFailover(addrs []string, opts ...grpc.DialOption) (*failover, error) {
+	f := &failover{opts: opts, conns: make(map[string]*grpc.ClientConn), failed: make(map[string]time.Time)}
+	for _, addr := range addrs {
+		if _, err := f.add(addr); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// add dials addr unless it is known already and returns its index. Callers hold
+// mu, but for newFailover, which runs before anyone else can.
+func (f *failover) add(addr string) (int, error) {
+	for i, known := range f.addrs {
+		if known == addr {
+			return i, nil
+		}
+	}
+	//Dialing doesn't wait for the server, it may well be down
+	conn, err := grpc.Dial(addr, f.opts...)
+	if err != nil {
+		return 0, err
+	}
+	f.addrs = append(f.addrs, addr)
+	f.conns[addr] = conn
+	return len(f.addrs) - 1, nil
+}
+
+// Close closes every connection
+func (f *failover) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, conn := range f.conns {
+		conn.Close()
+	}
+	return nil
+}
+
+// pick returns the server calls go to now
+func (f *failover) pick() (string, *grpc.ClientConn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	addr := f.addrs[f.current]
+	return addr, f.conns[addr]
+}
+
+// moveOn sends calls to the server after addr, unless another call moved on from it already
+func (f *failover) moveOn(addr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.addrs) < 2 || f.addrs[f.current] != addr {
+		return
+	}
+	f.current = (f.current + 1) % len(f.addrs)
+	log.Println("Moving on from " + addr + " to " + f.addrs[f.current])
+}
+
+// down records that addr failed a call and moves on from it
+func (f *failover) down(addr string) {
+	f.mu.Lock()
+	f.failed[addr] = time.Now()
+	f.mu.Unlock()
+	f.moveOn(addr)
+}
+
+// recentlyDown reports whether addr failed a call within the last second. Followers
+// go on naming a leader that died until they notice, which takes about as long.
+func (f *failover) recentlyDown(addr string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Since(f.failed[addr]) < time.Second
+}
+
+// follow sends calls to the leader, which need not be one of the servers we were given
+func (f *failover) follow(leader string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	i, err := f.add(leader)
+	if err != nil {
+		return err
+	}
+	if i != f.current {
+		f.current = i
+		log.Println("Following the leader to " + leader)
+	}
+	return nil
+}
+
+// tries is how many servers a call may be sent on to before its error is returned,
+// enough to go round all of them and follow a leader from each
+func (f *failover) tries() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return 2*len(f.addrs) + 1
+}
+
+// retrySafe reports whether a call can go to another server after it failed in a
+// way that leaves open whether the first one carried it out
+func retrySafe(method string, args interface{}) bool {
+	switch method {
+	case "/Route/Heartbeat", "/Route/Fetch", "/Route/History", "/Route/ListRooms":
+		return true
+	case "/Route/Connect":
+		//Resuming a session does the same thing however often it's done
+		return args.(*pb.ConnectRequest).GetToken() != ""
+	case "/Route/SayHello", "/Route/BroadcastMessage", "/Route/SendDirect":
+		//The server answers a message it has seen before with what it broadcast the first time
+		return args.(*pb.RequestText).GetRequestId() != nil
+	}
+	return false
+}
+
+func (f *failover) Invoke(ctx context.Context, method string, args, reply interface{}, opts ...grpc.CallOption) error {
+	var err error
+	for tries := f.tries(); tries > 0; tries-- {
+		addr, conn := f.pick()
+		err = conn.Invoke(ctx, method, args, reply, opts...)
+		switch {
+		case err == nil:
+			return nil
+		case reason(err) == pb.ErrorReason_NOT_LEADER:
+			//Followers turn calls away before doing anything, they can always go to the leader
+			to := leader(err)
+			if to != "" && to != addr && !f.recentlyDown(to) && f.follow(to) == nil {
+				continue
+			}
+			//No leader yet, or one still catching up or about to be replaced, give it a moment
+			if to == "" {
+				f.moveOn(addr)
+			}
+			select {
+			case <-ctx.Done():
+				return err
+			case <-time.After(100 * time.Millisecond):
+			}
+		case reason(err) == pb.ErrorReason_SHUTTING_DOWN:
+			//A server that is going turns new calls away untouched
+			f.down(addr)
+		case status.Code(err) == codes.Unavailable:
+			f.down(addr)
+			if !retrySafe(method, args) {
+				return err
+			}
+		default:
+			return err
+		}
+	}
+	return err
+}
+
+func (f *failover) NewStream(ctx context.Context, desc *grpc.StreamDesc, method string, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	//Nothing has been sent on a stream that couldn't be opened
+	for tries := f.tries(); ; tries-- {
+		addr, conn := f.pick()
+		stream, err := conn.NewStream(ctx, desc, method, opts...)
+		if err == nil || tries <= 1 || status.Code(err) != codes.Unavailable {
+			return stream, err
+		}
+		f.down(addr)
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	pb "program/route"
+	"sync"
+)
+
+// outbox holds the text sent on the chat stream until it comes back as a broadcast,
+// so whatever a broken stream may have lost can be sent again
+type outbox struct {
+	mu    sync.Mutex
+	texts []*pb.RequestText
+}
+
+func (o *outbox) add(text *pb.RequestText) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.texts = append(o.texts, text)
+}
+
+// arrived drops the text sent with id, and everything sent before it. The server takes
+// a stream's text in order, so those were either broadcast already or turned away
+func (o *outbox) arrived(id *pb.RequestId) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for i, text := range o.texts {
+		if text.RequestId.GetSeq() == id.GetSeq() {
+			o.texts = append([]*pb.RequestText(nil), o.texts[i+1:]...)
+			return
+		}
+	}
+}
+
+// take empties the outbox and returns what was in it, in the order it was sent
+func (o *outbox) take() []*pb.RequestText {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	texts := o.texts
+	o.texts = nil
+	return texts
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"log"
+	pb "program/route"
+	"time"
+)
+
+// transcript puts the server's broadcasts in sequence order, fetching any
+// that were lost on the way so every client shows the same conversation.
+type transcript struct {
+	client  pb.RouteClient
+	me      *pb.Client
+	lastSeq uint64
+}
+
+// accept takes a message off the stream and returns the messages to show, in order.
+func (t *transcript) accept(msg *pb.GenericText) []*pb.GenericText {
+	//Private notices are not part of the broadcast order
+	if msg.Seq == 0 {
+		return []*pb.GenericText{msg}
+	}
+	//Already seen, e.g. fetched while filling an earlier gap
+	if msg.Seq <= t.lastSeq {
+		return nil
+	}
+
+	//Broadcasts to rooms we are not in leave holes in the numbers, only one we could
+	//have seen and didn't is missing
+	var ordered []*pb.GenericText
+	if t.lastSeq != 0 && msg.Prev > t.lastSeq {
+		ordered = t.fetch(t.lastSeq+1, msg.Prev)
+	}
+	t.lastSeq = msg.Seq
+	return append(ordered, msg)
+}
+
+// replay returns the broadcasts after the last one seen, up to and including to,
+// for catching up after being disconnected
+func (t *transcript) replay(to uint64) []*pb.GenericText {
+	//The server came back without its history, start counting again from where it is
+	if to < t.lastSeq {
+		log.Printf("the server lost messages %d-%d", to+1, t.lastSeq)
+		t.lastSeq = to
+		return nil
+	}
+	if t.lastSeq == 0 || to == t.lastSeq {
+		t.lastSeq = to
+		return nil
+	}
+	msgs := t.fetch(t.lastSeq+1, to)
+	t.lastSeq = to
+	return msgs
+}
+
+// fetch asks the server to resend the broadcasts from..to, a page at a time
+func (t *transcript) fetch(from, to uint64) []*pb.GenericText {
+	var msgs []*pb.GenericText
+	for from <= to {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		reply, err := t.client.Fetch(ctx, &pb.FetchRequest{Client: t.me, FromSeq: from, ToSeq: to})
+		cancel()
+		if err != nil {
+			log.Printf("could not fetch missed messages %d-%d: %v", from, to, err)
+			return msgs
+		}
+		//Fewer than asked for is normal, the rest went to rooms we are not in
+		msgs = append(msgs, reply.Messages...)
+		if reply.ToSeq < from {
+			break
+		}
+		from = reply.ToSeq + 1
+	}
+	return msgs
+}
@@ -0,0 +1,48 @@
+// Package clock has the logical clocks used to order chat messages.
+package clock
+
+import "sync"
+
+// Lamport is a Lamport logical clock. The zero value is ready to use and safe
+// for concurrent use.
+type Lamport struct {
+	mu   sync.Mutex
+	time uint64
+}
+
+// Tick advances the clock for a local or send event and returns the new time.
+func (l *Lamport) Tick() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.time++
+	return l.time
+}
+
+// Witness merges a timestamp carried by a received message, moving the clock
+// to max(local, t)+1, and returns the new time.
+func (l *Lamport) Witness(t uint64) uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t > l.time {
+		l.time = t
+	}
+	l.time++
+	return l.time
+}
+
+// Now returns the current time without advancing the clock.
+func (l *Lamport) Now() uint64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.time
+}
+
+// Advance moves the clock forward to t if it is behind, without counting an
+// event. It is used when rebuilding state from timestamps that were already issued.
+func (l *Lamport) Advance(t uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if t > l.time {
+		l.time = t
+	}
+}
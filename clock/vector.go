@@ -0,0 +1,22 @@
+package clock
+
+// Vector is a vector clock keyed by client id. A missing entry counts as zero.
+type Vector map[int64]uint64
+
+// Copy returns an independent copy of v.
+func (v Vector) Copy() Vector {
+	c := make(Vector, len(v))
+	for id, t := range v {
+		c[id] = t
+	}
+	return c
+}
+
+// Merge raises every entry of v to at least the one in other.
+func (v Vector) Merge(other Vector) {
+	for id, t := range other {
+		if t > v[id] {
+			v[id] = t
+		}
+	}
+}
@@ -0,0 +1,234 @@
+package election
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"log"
+	pb "program/route"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Bully elects the live server with the highest id. A server that finds the
+// coordinator gone asks every server above it to take over; if none answers
+// it becomes coordinator itself and tells the rest. A server that comes back
+// holds an election straight away, so it takes over from any lower coordinator.
+type Bully struct {
+	self    Peer
+	others  []Peer
+	timeout time.Duration
+	peers   *peers
+
+	mu          sync.Mutex
+	coordinator Peer
+	electing    bool
+	//Closed when a coordinator is announced during an election
+	announced chan struct{}
+
+	stopped chan struct{}
+	running sync.WaitGroup
+}
+
+// NewBully sets up the election of self among group, which may list self too.
+// timeout bounds every wait for an answer and is how often the coordinator is checked on.
+func NewBully(self Peer, group []Peer, timeout time.Duration, opts ...grpc.DialOption) *Bully {
+	b := &Bully{self: self, timeout: timeout, peers: newPeers(opts), stopped: make(chan struct{})}
+	for _, p := range group {
+		if p.ID != self.ID {
+			b.others = append(b.others, p)
+		}
+	}
+	return b
+}
+
+// Start holds the first election and keeps checking that the coordinator is alive
+func (b *Bully) Start() {
+	b.running.Add(1)
+	go b.watch()
+}
+
+// Stop stops checking on the coordinator and taking part in elections
+func (b *Bully) Stop() {
+	close(b.stopped)
+	b.running.Wait()
+	b.peers.close()
+}
+
+// CurrentLeader returns the coordinator, and false while there is none
+func (b *Bully) CurrentLeader() (Peer, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.coordinator, b.coordinator.ID != 0
+}
+
+// Leader returns the coordinator's address, empty while there is none
+func (b *Bully) Leader() string {
+	coordinator, _ := b.CurrentLeader()
+	return coordinator.Addr
+}
+
+// IsLeader reports whether this server is the coordinator
+func (b *Bully) IsLeader() bool {
+	coordinator, ok := b.CurrentLeader()
+	return ok && coordinator.ID == b.self.ID
+}
+
+func (b *Bully) watch() {
+	defer b.running.Done()
+	b.elect()
+	ticker := time.NewTicker(b.timeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-b.stopped:
+			return
+		case <-ticker.C:
+		}
+		coordinator, ok := b.CurrentLeader()
+		switch {
+		case !ok:
+			b.elect()
+		case coordinator.ID == b.self.ID:
+		case !b.peers.alive(b.self, coordinator, b.timeout):
+			log.Println("election: coordinator " + strconv.FormatInt(coordinator.ID, 10) + " is gone")
+			b.elect()
+		}
+	}
+}
+
+// elect holds an election, unless one is already under way
+func (b *Bully) elect() {
+	b.mu.Lock()
+	if b.electing {
+		b.mu.Unlock()
+		return
+	}
+	b.electing = true
+	b.coordinator = Peer{}
+	announced := make(chan struct{})
+	b.announced = announced
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.electing = false
+		b.announced = nil
+		b.mu.Unlock()
+	}()
+
+	for {
+		if !b.challenge() {
+			b.win()
+			return
+		}
+		//A higher server is alive and holds its own election, wait for it to announce the winner
+		select {
+		case <-announced:
+			return
+		case <-b.stopped:
+			return
+		case <-time.After(2 * b.timeout):
+			log.Println("election: no coordinator announced, electing again")
+		}
+	}
+}
+
+// challenge sends an election message to every server with a higher id and
+// reports whether any of them answered
+func (b *Bully) challenge() bool {
+	answered := make(chan bool)
+	count := 0
+	for _, p := range b.others {
+		if p.ID < b.self.ID {
+			continue
+		}
+		count++
+		go func(p Peer) {
+			ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+			defer cancel()
+			client, err := b.peers.client(p.Addr)
+			if err == nil {
+				_, err = client.Elect(ctx, &pb.Election{Candidate: b.self.info()})
+			}
+			answered <- err == nil
+		}(p)
+	}
+	taken := false
+	for i := 0; i < count; i++ {
+		taken = <-answered || taken
+	}
+	return taken
+}
+
+// win makes this server the coordinator and tells everyone else
+func (b *Bully) win() {
+	b.mu.Lock()
+	b.coordinator = b.self
+	b.mu.Unlock()
+	log.Println("election: " + strconv.FormatInt(b.self.ID, 10) + " is the coordinator")
+
+	for _, p := range b.others {
+		go func(p Peer) {
+			ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+			defer cancel()
+			if client, err := b.peers.client(p.Addr); err == nil {
+				client.Announce(ctx, &pb.Coordinator{Coordinator: b.self.info()})
+			}
+		}(p)
+	}
+}
+
+func (b *Bully) answer() *pb.Answer {
+	coordinator, _ := b.CurrentLeader()
+	return &pb.Answer{From: b.self.info(), Coordinator: coordinator.info()}
+}
+
+func (b *Bully) handleElection(candidate Peer) *pb.Answer {
+	//Answering tells the candidate to stand down, this server takes over the election
+	if candidate.ID < b.self.ID {
+		go b.elect()
+	}
+	return b.answer()
+}
+
+func (b *Bully) handleCoordinator(coordinator Peer) *pb.Answer {
+	//A lower server can't lead while this one is alive
+	if coordinator.ID < b.self.ID {
+		go b.elect()
+		return b.answer()
+	}
+	b.mu.Lock()
+	changed := b.coordinator != coordinator
+	b.coordinator = coordinator
+	if b.announced != nil {
+		close(b.announced)
+		b.announced = nil
+	}
+	b.mu.Unlock()
+	if changed {
+		log.Println("election: " + strconv.FormatInt(coordinator.ID, 10) + " is the coordinator")
+	}
+	return b.answer()
+}
+
+// Register serves the bully side of the Peer RPCs on s
+func (b *Bully) Register(s *grpc.Server) {
+	pb.RegisterPeerServer(s, &bullyService{bully: b})
+}
+
+type bullyService struct {
+	pb.UnimplementedPeerServer
+	bully *Bully
+}
+
+func (s *bullyService) Elect(ctx context.Context, in *pb.Election) (*pb.Answer, error) {
+	return s.bully.handleElection(peerOf(in.Candidate)), nil
+}
+
+func (s *bullyService) Announce(ctx context.Context, in *pb.Coordinator) (*pb.Answer, error) {
+	return s.bully.handleCoordinator(peerOf(in.Coordinator)), nil
+}
+
+func (s *bullyService) Alive(ctx context.Context, in *pb.Ping) (*pb.Answer, error) {
+	return s.bully.answer(), nil
+}
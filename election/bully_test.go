@@ -0,0 +1,193 @@
+package election
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"net"
+	pb "program/route"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// elector is what the tests need of a Bully or a Ring
+type elector interface {
+	Register(s *grpc.Server)
+	Start()
+	Stop()
+	CurrentLeader() (Peer, bool)
+	Leader() string
+	IsLeader() bool
+}
+
+// algorithm sets up the election a server in the test runs
+type algorithm func(self Peer, group []Peer) elector
+
+func bully(self Peer, group []Peer) elector {
+	return NewBully(self, group, 100*time.Millisecond, grpc.WithInsecure())
+}
+
+func ring(self Peer, group []Peer) elector {
+	return NewRing(self, group, 100*time.Millisecond, grpc.WithInsecure())
+}
+
+// node is one server of an election running in the test
+type node struct {
+	self     Peer
+	group    []Peer
+	elect    algorithm
+	election elector
+	g        *grpc.Server
+	running  bool
+
+	//Closed instead of passing on the next ring election message, set by holdNextToken
+	mu   sync.Mutex
+	held chan struct{}
+}
+
+// startGroup starts n servers with ids 1 to n on free local ports, all running elect
+func startGroup(t *testing.T, n int, elect algorithm) []*node {
+	t.Helper()
+	var group []Peer
+	var listeners []net.Listener
+	for id := int64(1); id <= int64(n); id++ {
+		lis, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		listeners = append(listeners, lis)
+		group = append(group, Peer{ID: id, Addr: lis.Addr().String()})
+	}
+	nodes := make([]*node, n)
+	for i, p := range group {
+		nodes[i] = &node{self: p, group: group, elect: elect}
+		nodes[i].serve(listeners[i])
+	}
+	t.Cleanup(func() {
+		for _, n := range nodes {
+			n.stop()
+		}
+	})
+	return nodes
+}
+
+func (n *node) serve(lis net.Listener) {
+	n.election = n.elect(n.self, n.group)
+	n.g = grpc.NewServer(grpc.UnaryInterceptor(n.intercept))
+	n.election.Register(n.g)
+	go n.g.Serve(lis)
+	n.election.Start()
+	n.running = true
+}
+
+// holdNextToken makes the server take the next ring election message it is handed
+// without passing it on, and returns a channel closed once it has
+func (n *node) holdNextToken() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.held = make(chan struct{})
+	return n.held
+}
+
+func (n *node) intercept(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !strings.HasSuffix(info.FullMethod, "/RingElection") {
+		return handler(ctx, req)
+	}
+	n.mu.Lock()
+	held := n.held
+	n.held = nil
+	n.mu.Unlock()
+	if held == nil {
+		return handler(ctx, req)
+	}
+	//Answer as if it was passed on, then the test kills the server
+	close(held)
+	return &pb.Answer{From: n.self.info()}, nil
+}
+
+// stop kills the server, it stops answering at once
+func (n *node) stop() {
+	if !n.running {
+		return
+	}
+	n.running = false
+	n.g.Stop()
+	n.election.Stop()
+}
+
+// restart brings a stopped server back on its address
+func (n *node) restart(t *testing.T) {
+	t.Helper()
+	lis, err := net.Listen("tcp", n.self.Addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n.serve(lis)
+}
+
+// waitCoordinator fails the test unless every running server takes want for the
+// coordinator within a few seconds
+func waitCoordinator(t *testing.T, nodes []*node, want int64) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		agreed := true
+		for _, n := range nodes {
+			if !n.running {
+				continue
+			}
+			coordinator, ok := n.election.CurrentLeader()
+			agreed = agreed && ok && coordinator.ID == want
+		}
+		if agreed {
+			return
+		}
+		if time.Now().After(deadline) {
+			for _, n := range nodes {
+				if n.running {
+					coordinator, _ := n.election.CurrentLeader()
+					t.Logf("%d takes %d for the coordinator", n.self.ID, coordinator.ID)
+				}
+			}
+			t.Fatalf("timed out waiting for %d to be the coordinator", want)
+		}
+	}
+}
+
+func TestHighestIDIsElected(t *testing.T) {
+	nodes := startGroup(t, 3, bully)
+	waitCoordinator(t, nodes, 3)
+	for _, n := range nodes {
+		if n.election.IsLeader() != (n.self.ID == 3) {
+			t.Fatalf("%d: IsLeader = %v", n.self.ID, n.election.IsLeader())
+		}
+		if n.election.Leader() != nodes[2].self.Addr {
+			t.Fatalf("%d: Leader = %q, want %q", n.self.ID, n.election.Leader(), nodes[2].self.Addr)
+		}
+	}
+}
+
+func TestNextHighestTakesOverFromDeadCoordinator(t *testing.T) {
+	nodes := startGroup(t, 4, bully)
+	waitCoordinator(t, nodes, 4)
+
+	nodes[3].stop()
+	waitCoordinator(t, nodes, 3)
+
+	nodes[2].stop()
+	waitCoordinator(t, nodes, 2)
+}
+
+func TestReturningServerTakesOver(t *testing.T) {
+	nodes := startGroup(t, 3, bully)
+	waitCoordinator(t, nodes, 3)
+	nodes[2].stop()
+	waitCoordinator(t, nodes, 2)
+
+	//It holds an election as soon as it is back, and bullies 2 out of the way
+	nodes[2].restart(t)
+	waitCoordinator(t, nodes, 3)
+	if nodes[1].election.IsLeader() {
+		t.Fatal("2 still leads after 3 came back")
+	}
+}
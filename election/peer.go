@@ -0,0 +1,78 @@
+// Package election picks a leader among a fixed group of servers that don't
+// replicate their state, so that only one of them takes clients at a time.
+package election
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	pb "program/route"
+	"sync"
+	"time"
+)
+
+// A Peer is one server of the group, known by an id unique within it
+type Peer struct {
+	ID   int64
+	Addr string
+}
+
+func (p Peer) info() *pb.PeerInfo {
+	return &pb.PeerInfo{Id: p.ID, Address: p.Addr}
+}
+
+func peerOf(info *pb.PeerInfo) Peer {
+	return Peer{ID: info.GetId(), Addr: info.GetAddress()}
+}
+
+// peers keeps one connection to each server of the group, made when it is first needed
+type peers struct {
+	opts  []grpc.DialOption
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+func newPeers(opts []grpc.DialOption) *peers {
+	//A server that comes back must be reached again by the next check, not after gRPC's default backoff
+	reconnect := grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff:           backoff.Config{BaseDelay: 100 * time.Millisecond, Multiplier: 1.6, Jitter: 0.2, MaxDelay: time.Second},
+		MinConnectTimeout: time.Second,
+	})
+	return &peers{opts: append([]grpc.DialOption{reconnect}, opts...), conns: make(map[string]*grpc.ClientConn)}
+}
+
+func (p *peers) client(addr string) (pb.PeerClient, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	conn, ok := p.conns[addr]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(addr, p.opts...)
+		if err != nil {
+			return nil, err
+		}
+		p.conns[addr] = conn
+	}
+	return pb.NewPeerClient(conn), nil
+}
+
+// alive asks the leader whether it is still there, on behalf of self
+func (p *peers) alive(self, leader Peer, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	client, err := p.client(leader.Addr)
+	if err != nil {
+		return false
+	}
+	_, err = client.Alive(ctx, &pb.Ping{From: self.info()})
+	return err == nil
+}
+
+func (p *peers) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for addr, conn := range p.conns {
+		conn.Close()
+		delete(p.conns, addr)
+	}
+}
@@ -0,0 +1,239 @@
+package election
+
+import (
+	"context"
+	"google.golang.org/grpc"
+	"log"
+	pb "program/route"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Ring elects the live server with the highest id after Chang and Roberts. The
+// servers form a ring in order of id and an election message goes round it,
+// each server passing on the higher of its own id and the one it was handed.
+// When the message reaches the server it names it has been all the way round,
+// so that server leads and sends the result round as well. A server that
+// doesn't answer is skipped and the message goes to the one after it.
+type Ring struct {
+	self Peer
+	//Everyone after self round the ring, in the order messages are passed on
+	successors []Peer
+	timeout    time.Duration
+	peers      *peers
+
+	mu     sync.Mutex
+	leader Peer
+	//Set while an election this server has passed on is under way, since joined
+	participant bool
+	joined      time.Time
+
+	stopped chan struct{}
+	running sync.WaitGroup
+}
+
+// NewRing sets up the election of self among group, which may list self too.
+// timeout bounds every wait for an answer and is how often the leader is checked on.
+func NewRing(self Peer, group []Peer, timeout time.Duration, opts ...grpc.DialOption) *Ring {
+	r := &Ring{self: self, timeout: timeout, peers: newPeers(opts), stopped: make(chan struct{})}
+	var above, below []Peer
+	for _, p := range group {
+		switch {
+		case p.ID > self.ID:
+			above = append(above, p)
+		case p.ID < self.ID:
+			below = append(below, p)
+		}
+	}
+	sort.Slice(above, func(i, j int) bool { return above[i].ID < above[j].ID })
+	sort.Slice(below, func(i, j int) bool { return below[i].ID < below[j].ID })
+	r.successors = append(above, below...)
+	return r
+}
+
+// Start holds the first election and keeps checking that the leader is alive
+func (r *Ring) Start() {
+	r.running.Add(1)
+	go r.watch()
+}
+
+// Stop stops checking on the leader and taking part in elections
+func (r *Ring) Stop() {
+	close(r.stopped)
+	r.running.Wait()
+	r.peers.close()
+}
+
+// CurrentLeader returns the leader, and false while there is none
+func (r *Ring) CurrentLeader() (Peer, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.leader, r.leader.ID != 0
+}
+
+// Leader returns the leader's address, empty while there is none
+func (r *Ring) Leader() string {
+	leader, _ := r.CurrentLeader()
+	return leader.Addr
+}
+
+// IsLeader reports whether this server leads
+func (r *Ring) IsLeader() bool {
+	leader, ok := r.CurrentLeader()
+	return ok && leader.ID == r.self.ID
+}
+
+func (r *Ring) watch() {
+	defer r.running.Done()
+	r.elect()
+	ticker := time.NewTicker(r.timeout)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.stopped:
+			return
+		case <-ticker.C:
+		}
+		leader, ok := r.CurrentLeader()
+		switch {
+		case !ok:
+			r.elect()
+		case leader.ID == r.self.ID:
+		case !r.peers.alive(r.self, leader, r.timeout):
+			log.Println("election: leader " + strconv.FormatInt(leader.ID, 10) + " is gone")
+			r.elect()
+		}
+	}
+}
+
+// elect sends an election message round the ring, unless this server already
+// passed one on that may still come back
+func (r *Ring) elect() {
+	r.mu.Lock()
+	if r.participating() {
+		r.mu.Unlock()
+		return
+	}
+	r.join()
+	r.leader = Peer{}
+	r.mu.Unlock()
+	log.Println("election: " + strconv.FormatInt(r.self.ID, 10) + " starts an election round the ring")
+	go r.pass(false, r.self)
+}
+
+// participating reports whether an election this server passed on may still be
+// going round. One that hasn't come back after a full round was lost with a
+// server that died holding it. Callers hold mu.
+func (r *Ring) participating() bool {
+	return r.participant && time.Since(r.joined) < time.Duration(len(r.successors)+1)*r.timeout
+}
+
+func (r *Ring) join() {
+	r.participant = true
+	r.joined = time.Now()
+}
+
+// pass hands the election message, or the result when elected is set, for
+// candidate to the next live server round the ring
+func (r *Ring) pass(elected bool, candidate Peer) {
+	token := &pb.RingToken{Candidate: candidate.info()}
+	for _, next := range r.successors {
+		select {
+		case <-r.stopped:
+			return
+		default:
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+		client, err := r.peers.client(next.Addr)
+		if err == nil {
+			if elected {
+				_, err = client.RingElected(ctx, token)
+			} else {
+				_, err = client.RingElection(ctx, token)
+			}
+		}
+		cancel()
+		if err == nil {
+			return
+		}
+		log.Println("election: " + strconv.FormatInt(next.ID, 10) + " does not answer, skipping it")
+		//A dead candidate would never see the message come back. Every server on the way
+		//was below it, so this one is the highest alive and stands in its place.
+		if !elected && next.ID == candidate.ID {
+			candidate = r.self
+			token = &pb.RingToken{Candidate: candidate.info()}
+		}
+	}
+	//Nobody else is alive, so the message comes straight back here
+	if !elected {
+		r.handleElection(candidate)
+	}
+}
+
+func (r *Ring) answer() *pb.Answer {
+	leader, _ := r.CurrentLeader()
+	return &pb.Answer{From: r.self.info(), Coordinator: leader.info()}
+}
+
+func (r *Ring) handleElection(candidate Peer) *pb.Answer {
+	r.mu.Lock()
+	switch {
+	case candidate.ID == r.self.ID:
+		//It went all the way round without meeting a higher id
+		r.participant = false
+		r.leader = r.self
+		log.Println("election: " + strconv.FormatInt(r.self.ID, 10) + " is the leader")
+		go r.pass(true, r.self)
+	case candidate.ID > r.self.ID:
+		r.join()
+		go r.pass(false, candidate)
+	case !r.participating():
+		r.join()
+		go r.pass(false, r.self)
+	default:
+		//This server already passed on its own id or a higher one, which wins over candidate
+	}
+	r.mu.Unlock()
+	return r.answer()
+}
+
+func (r *Ring) handleElected(leader Peer) *pb.Answer {
+	r.mu.Lock()
+	participant := r.participant
+	changed := r.leader != leader
+	r.participant = false
+	r.leader = leader
+	r.mu.Unlock()
+	if changed {
+		log.Println("election: " + strconv.FormatInt(leader.ID, 10) + " is the leader")
+	}
+	//Stop once it is back at the leader, or at a server that already knew, in case the leader died
+	if leader.ID != r.self.ID && (participant || changed) {
+		go r.pass(true, leader)
+	}
+	return r.answer()
+}
+
+// Register serves the ring side of the Peer RPCs on s
+func (r *Ring) Register(s *grpc.Server) {
+	pb.RegisterPeerServer(s, &ringService{ring: r})
+}
+
+type ringService struct {
+	pb.UnimplementedPeerServer
+	ring *Ring
+}
+
+func (s *ringService) RingElection(ctx context.Context, in *pb.RingToken) (*pb.Answer, error) {
+	return s.ring.handleElection(peerOf(in.Candidate)), nil
+}
+
+func (s *ringService) RingElected(ctx context.Context, in *pb.RingToken) (*pb.Answer, error) {
+	return s.ring.handleElected(peerOf(in.Candidate)), nil
+}
+
+func (s *ringService) Alive(ctx context.Context, in *pb.Ping) (*pb.Answer, error) {
+	return s.ring.answer(), nil
+}
@@ -0,0 +1,47 @@
+package election
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRingElectsHighestID(t *testing.T) {
+	nodes := startGroup(t, 3, ring)
+	waitCoordinator(t, nodes, 3)
+	for _, n := range nodes {
+		if n.election.IsLeader() != (n.self.ID == 3) {
+			t.Fatalf("%d: IsLeader = %v", n.self.ID, n.election.IsLeader())
+		}
+		if n.election.Leader() != nodes[2].self.Addr {
+			t.Fatalf("%d: Leader = %q, want %q", n.self.ID, n.election.Leader(), nodes[2].self.Addr)
+		}
+	}
+}
+
+func TestRingElectsAgainWhenTheLeaderDies(t *testing.T) {
+	nodes := startGroup(t, 4, ring)
+	waitCoordinator(t, nodes, 4)
+
+	nodes[3].stop()
+	waitCoordinator(t, nodes, 3)
+
+	nodes[2].stop()
+	waitCoordinator(t, nodes, 2)
+}
+
+func TestRingSurvivesTheTokenDyingWithTheLeadersSuccessor(t *testing.T) {
+	nodes := startGroup(t, 4, ring)
+	waitCoordinator(t, nodes, 4)
+
+	//1 comes after 4 round the ring, so every election message for 4's place reaches it.
+	//It dies holding the first one
+	held := nodes[0].holdNextToken()
+	nodes[3].stop()
+	select {
+	case <-held:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no election message reached 1")
+	}
+	nodes[0].stop()
+	waitCoordinator(t, nodes, 3)
+}
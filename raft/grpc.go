@@ -0,0 +1,162 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "program/route"
+	"sync"
+	"time"
+)
+
+// GRPCTransport reaches the other nodes over gRPC, at the address they are named by.
+// Connections are made the first time a node is needed and kept.
+type GRPCTransport struct {
+	opts  []grpc.DialOption
+	mu    sync.Mutex
+	conns map[string]*grpc.ClientConn
+}
+
+// NewGRPCTransport makes a transport that dials with opts
+func NewGRPCTransport(opts ...grpc.DialOption) *GRPCTransport {
+	//A node that comes back must be reached again within an election timeout or so,
+	//not after gRPC's default backoff of up to two minutes
+	reconnect := grpc.WithConnectParams(grpc.ConnectParams{
+		Backoff:           backoff.Config{BaseDelay: 100 * time.Millisecond, Multiplier: 1.6, Jitter: 0.2, MaxDelay: time.Second},
+		MinConnectTimeout: time.Second,
+	})
+	return &GRPCTransport{opts: append([]grpc.DialOption{reconnect}, opts...), conns: make(map[string]*grpc.ClientConn)}
+}
+
+func (t *GRPCTransport) client(addr string) (pb.RaftClient, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	conn, ok := t.conns[addr]
+	if !ok {
+		var err error
+		conn, err = grpc.Dial(addr, t.opts...)
+		if err != nil {
+			return nil, err
+		}
+		t.conns[addr] = conn
+	}
+	return pb.NewRaftClient(conn), nil
+}
+
+func (t *GRPCTransport) RequestVote(ctx context.Context, to string, req *pb.VoteRequest) (*pb.VoteReply, error) {
+	client, err := t.client(to)
+	if err != nil {
+		return nil, err
+	}
+	return client.RequestVote(ctx, req)
+}
+
+func (t *GRPCTransport) AppendEntries(ctx context.Context, to string, req *pb.AppendRequest) (*pb.AppendReply, error) {
+	client, err := t.client(to)
+	if err != nil {
+		return nil, err
+	}
+	return client.AppendEntries(ctx, req)
+}
+
+func (t *GRPCTransport) InstallSnapshot(ctx context.Context, to string, req *pb.SnapshotRequest) (*pb.SnapshotReply, error) {
+	client, err := t.client(to)
+	if err != nil {
+		return nil, err
+	}
+	return client.InstallSnapshot(ctx, req)
+}
+
+// Close closes every connection
+func (t *GRPCTransport) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for addr, conn := range t.conns {
+		conn.Close()
+		delete(t.conns, addr)
+	}
+	return nil
+}
+
+// Join asks the cluster that seed belongs to to add node as a voting member
+func (t *GRPCTransport) Join(ctx context.Context, seed, node string) (*pb.Membership, error) {
+	return t.changeMembership(ctx, seed, &pb.MembershipChange{Node: node})
+}
+
+// Leave asks the cluster that seed belongs to to remove node
+func (t *GRPCTransport) Leave(ctx context.Context, seed, node string) (*pb.Membership, error) {
+	return t.changeMembership(ctx, seed, &pb.MembershipChange{Node: node, Remove: true})
+}
+
+// changeMembership sends the change to seed and then wherever it says the leader is,
+// until the leader takes it or ctx ends
+func (t *GRPCTransport) changeMembership(ctx context.Context, seed string, change *pb.MembershipChange) (*pb.Membership, error) {
+	to := seed
+	for {
+		client, err := t.client(to)
+		if err != nil {
+			return nil, err
+		}
+		reply, err := client.ChangeMembership(ctx, change)
+		if err != nil {
+			return nil, err
+		}
+		if reply.Membership != nil {
+			return reply.Membership, nil
+		}
+		//No leader yet, or one that is still catching up, ask again shortly
+		if reply.Leader == "" || reply.Leader == to {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(100 * time.Millisecond):
+			}
+			if reply.Leader == "" {
+				to = seed
+			}
+			continue
+		}
+		to = reply.Leader
+	}
+}
+
+// Register serves the node's side of the Raft RPCs on s
+func Register(s *grpc.Server, n *Node) {
+	pb.RegisterRaftServer(s, &service{node: n})
+}
+
+type service struct {
+	pb.UnimplementedRaftServer
+	node *Node
+}
+
+func (s *service) RequestVote(ctx context.Context, req *pb.VoteRequest) (*pb.VoteReply, error) {
+	return s.node.handleVote(req), nil
+}
+
+func (s *service) AppendEntries(ctx context.Context, req *pb.AppendRequest) (*pb.AppendReply, error) {
+	return s.node.handleAppend(req), nil
+}
+
+func (s *service) InstallSnapshot(ctx context.Context, req *pb.SnapshotRequest) (*pb.SnapshotReply, error) {
+	return s.node.handleSnapshot(req), nil
+}
+
+func (s *service) ChangeMembership(ctx context.Context, req *pb.MembershipChange) (*pb.MembershipReply, error) {
+	if req.Node == "" {
+		return nil, status.Error(codes.InvalidArgument, "node is required")
+	}
+	membership, err := s.node.ChangeMembership(ctx, req.Node, req.Remove)
+	switch {
+	case err == nil:
+		return &pb.MembershipReply{Leader: s.node.id, Membership: membership}, nil
+	case errors.Is(err, ErrNotLeader):
+		return &pb.MembershipReply{Leader: s.node.Leader()}, nil
+	case errors.Is(err, ErrMembershipChange):
+		return nil, status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return nil, status.Error(codes.Unavailable, err.Error())
+}
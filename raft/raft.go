@@ -0,0 +1,673 @@
+// Package raft replicates a log of commands across a cluster of servers with
+// the Raft consensus algorithm, and applies the committed commands in the same
+// order to a state machine on every server.
+package raft
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"math/rand"
+	pb "program/route"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrNotLeader is returned by calls that only the leader can serve. Nothing was appended.
+	ErrNotLeader = errors.New("raft: not the leader")
+	// ErrLeadershipLost means the node stopped being leader before the entry was
+	// applied. The next leader may still commit it.
+	ErrLeadershipLost = errors.New("raft: leadership lost before the entry committed")
+	// ErrStopped is returned once Stop has been called
+	ErrStopped = errors.New("raft: node stopped")
+	// ErrMembershipChange is returned while an earlier membership change has yet to commit
+	ErrMembershipChange = errors.New("raft: another membership change is in progress")
+)
+
+// A StateMachine is what the log is replicated for. Every node applies the same
+// commands in the same order, so Apply must depend on nothing but its input and
+// the state it was left in.
+type StateMachine interface {
+	// Apply executes a committed command. What it returns is handed back to
+	// Propose on the node that proposed the command.
+	Apply(index uint64, data []byte) interface{}
+	// Snapshot serializes the state after the last applied command
+	Snapshot() ([]byte, error)
+	// Restore replaces the whole state with a snapshot
+	Restore(data []byte) error
+}
+
+// A Transport carries the Raft RPCs to other nodes, named by their ID
+type Transport interface {
+	RequestVote(ctx context.Context, to string, req *pb.VoteRequest) (*pb.VoteReply, error)
+	AppendEntries(ctx context.Context, to string, req *pb.AppendRequest) (*pb.AppendReply, error)
+	InstallSnapshot(ctx context.Context, to string, req *pb.SnapshotRequest) (*pb.SnapshotReply, error)
+}
+
+// Config sets up a Node
+type Config struct {
+	// ID names the node to the others, with the gRPC transport the address it serves on
+	ID string
+	// Peers is the membership to start with, this node included. It is ignored once
+	// the log holds a membership of its own. A node joining an existing cluster starts
+	// with none and waits to be added.
+	Peers []string
+
+	Storage   Storage
+	Transport Transport
+
+	// ElectionTimeout is how long a follower waits to hear from a leader before
+	// it stands for election, randomized up to twice as long
+	ElectionTimeout time.Duration
+	// HeartbeatInterval is how often the leader contacts idle followers
+	HeartbeatInterval time.Duration
+	// SnapshotEvery compacts the log after this many applied entries, 0 never does
+	SnapshotEvery uint64
+}
+
+type role int
+
+const (
+	follower role = iota
+	candidate
+	leader
+)
+
+func (r role) String() string {
+	switch r {
+	case candidate:
+		return "candidate"
+	case leader:
+		return "leader"
+	}
+	return "follower"
+}
+
+// The most entries, and roughly the most bytes, sent in one AppendEntries, and the
+// bytes of a snapshot sent in one InstallSnapshot. Both well under gRPC's 4MB limit.
+const (
+	maxBatch      = 64
+	maxBatchBytes = 1 << 20
+	snapshotChunk = 1 << 20
+)
+
+// A Node is one member of a Raft cluster
+type Node struct {
+	config Config
+	id     string
+	sm     StateMachine
+
+	mu       sync.Mutex
+	role     role
+	term     uint64
+	votedFor string
+	//The leader of the current term, empty while there is none
+	leader string
+	//When the leader was last heard from, and when to stand for election if it isn't again
+	lastContact time.Time
+	deadline    time.Time
+
+	//log[0] stands in for the last entry the snapshot covers, only its index and term are used
+	log      []*pb.LogEntry
+	snapshot *pb.RaftSnapshot
+	//The membership in force is the latest one appended, committed or not
+	membership  *pb.Membership
+	configIndex uint64
+
+	commitIndex uint64
+	lastApplied uint64
+	//A snapshot from the leader the apply loop has yet to restore
+	restore *pb.RaftSnapshot
+	//A snapshot from the leader that is still arriving, its data so far
+	incoming *pb.RaftSnapshot
+	//Wakes the apply loop
+	changed *sync.Cond
+	waiters map[uint64]*waiter
+
+	//Leader state, reset every term
+	leading     chan struct{}
+	next        map[string]uint64
+	match       map[string]uint64
+	acked       map[string]time.Time
+	replicators map[string]chan struct{}
+	//The entry every new leader appends, it serves once that is applied
+	noop  uint64
+	ready bool
+
+	halted  bool
+	stopped chan struct{}
+	running sync.WaitGroup
+}
+
+// A waiter is a Propose or a membership change waiting for its entry to be applied
+type waiter struct {
+	term   uint64
+	result chan applied
+}
+
+type applied struct {
+	value interface{}
+	err   error
+}
+
+// NewNode restores the node from its storage and starts it
+func NewNode(config Config, sm StateMachine) (*Node, error) {
+	state, snapshot, entries, err := config.Storage.Load()
+	if err != nil {
+		return nil, err
+	}
+	n := &Node{
+		config:  config,
+		id:      config.ID,
+		sm:      sm,
+		waiters: make(map[uint64]*waiter),
+		stopped: make(chan struct{}),
+	}
+	n.changed = sync.NewCond(&n.mu)
+	if state != nil {
+		n.term = state.Term
+		n.votedFor = state.VotedFor
+	}
+	n.log = []*pb.LogEntry{{}}
+	if snapshot != nil {
+		if err := sm.Restore(snapshot.Data); err != nil {
+			return nil, err
+		}
+		n.snapshot = snapshot
+		n.log[0] = &pb.LogEntry{Index: snapshot.Index, Term: snapshot.Term}
+		n.commitIndex = snapshot.Index
+		n.lastApplied = snapshot.Index
+	}
+	n.log = append(n.log, entries...)
+	n.refreshMembership()
+	n.resetDeadline()
+
+	n.running.Add(2)
+	go n.run()
+	go n.applyLoop()
+	return n, nil
+}
+
+// Stop halts the node. It can't be started again.
+func (n *Node) Stop() {
+	n.mu.Lock()
+	if n.halted {
+		n.mu.Unlock()
+		return
+	}
+	n.halted = true
+	close(n.stopped)
+	n.changed.Broadcast()
+	n.mu.Unlock()
+	n.running.Wait()
+}
+
+// Leader returns the ID of the current leader, empty when the node doesn't know one
+func (n *Node) Leader() string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.leader
+}
+
+// IsLeader reports whether the node is the leader and has caught up with
+// everything committed before its term, so it can serve requests
+func (n *Node) IsLeader() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.role == leader && n.ready
+}
+
+// Membership returns the voting members the node currently knows of
+func (n *Node) Membership() []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]string(nil), n.membership.GetVoters()...)
+}
+
+// Propose appends a command to the log and waits until it has been applied
+// here, returning what the state machine made of it. Only the leader accepts
+// proposals. When ctx ends first the command may still be applied later.
+func (n *Node) Propose(ctx context.Context, data []byte) (interface{}, error) {
+	n.mu.Lock()
+	if n.role != leader || !n.ready {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	w := n.wait(n.appendEntry(pb.EntryType_COMMAND, data))
+	n.maybeCommit()
+	n.mu.Unlock()
+	return n.await(ctx, w)
+}
+
+// ChangeMembership adds or removes one voting member and waits for the change
+// to commit. One change at a time, and only on the leader.
+func (n *Node) ChangeMembership(ctx context.Context, node string, remove bool) (*pb.Membership, error) {
+	n.mu.Lock()
+	if n.role != leader || !n.ready {
+		n.mu.Unlock()
+		return nil, ErrNotLeader
+	}
+	if n.configIndex > n.commitIndex {
+		n.mu.Unlock()
+		return nil, ErrMembershipChange
+	}
+	var voters []string
+	for _, v := range n.membership.GetVoters() {
+		if v != node {
+			voters = append(voters, v)
+		}
+	}
+	if !remove {
+		voters = append(voters, node)
+	}
+	//Already the way it was asked for
+	if len(voters) == len(n.membership.GetVoters()) {
+		current := n.membership
+		n.mu.Unlock()
+		return current, nil
+	}
+	membership := &pb.Membership{Voters: voters}
+	data, err := proto.Marshal(membership)
+	if err != nil {
+		n.mu.Unlock()
+		return nil, err
+	}
+	w := n.wait(n.appendEntry(pb.EntryType_CONFIG, data))
+	n.maybeCommit()
+	n.mu.Unlock()
+	if _, err := n.await(ctx, w); err != nil {
+		return nil, err
+	}
+	return membership, nil
+}
+
+func (n *Node) wait(index uint64) *waiter {
+	w := &waiter{term: n.term, result: make(chan applied, 1)}
+	n.waiters[index] = w
+	return w
+}
+
+func (n *Node) await(ctx context.Context, w *waiter) (interface{}, error) {
+	select {
+	case r := <-w.result:
+		return r.value, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-n.stopped:
+		return nil, ErrStopped
+	}
+}
+
+// run stands for election when the leader goes quiet, and makes a leader that
+// lost touch with the majority step down
+func (n *Node) run() {
+	defer n.running.Done()
+	ticker := time.NewTicker(n.config.ElectionTimeout / 10)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-n.stopped:
+			return
+		case now := <-ticker.C:
+			n.mu.Lock()
+			switch {
+			case n.role == leader:
+				if !n.heardFromQuorum(now) {
+					log.Println("raft: " + n.id + " lost touch with the majority, stepping down")
+					n.becomeFollower(n.term, "")
+				}
+			case now.After(n.deadline):
+				n.campaign()
+			}
+			n.mu.Unlock()
+		}
+	}
+}
+
+func (n *Node) resetDeadline() {
+	timeout := n.config.ElectionTimeout + time.Duration(rand.Int63n(int64(n.config.ElectionTimeout)))
+	n.deadline = time.Now().Add(timeout)
+}
+
+// campaign starts an election for the next term
+func (n *Node) campaign() {
+	n.resetDeadline()
+	//New nodes wait until the leader has added them
+	if !n.isVoter(n.id) {
+		return
+	}
+	n.role = candidate
+	n.term++
+	n.votedFor = n.id
+	n.leader = ""
+	n.persistState()
+	log.Printf("raft: %s standing for election in term %d", n.id, n.term)
+
+	term := n.term
+	req := &pb.VoteRequest{Term: term, Candidate: n.id, LastLogIndex: n.lastIndex(), LastLogTerm: n.lastTerm()}
+	votes := 1
+	if n.isQuorum(votes) {
+		n.becomeLeader()
+		return
+	}
+	for _, peer := range n.others() {
+		go func(peer string) {
+			ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+			reply, err := n.config.Transport.RequestVote(ctx, peer, req)
+			cancel()
+			if err != nil {
+				return
+			}
+			n.mu.Lock()
+			defer n.mu.Unlock()
+			if reply.Term > n.term {
+				n.becomeFollower(reply.Term, "")
+				return
+			}
+			if n.role != candidate || n.term != term || !reply.Granted {
+				return
+			}
+			votes++
+			if n.isQuorum(votes) {
+				n.becomeLeader()
+			}
+		}(peer)
+	}
+}
+
+func (n *Node) becomeLeader() {
+	log.Printf("raft: %s is leader for term %d", n.id, n.term)
+	n.role = leader
+	n.leader = n.id
+	n.leading = make(chan struct{})
+	n.next = make(map[string]uint64)
+	n.match = make(map[string]uint64)
+	n.acked = make(map[string]time.Time)
+	n.replicators = make(map[string]chan struct{})
+	n.ready = false
+	//Entries from earlier terms only commit along with one from this term
+	n.noop = n.appendEntry(pb.EntryType_NOOP, nil)
+	n.maybeCommit()
+}
+
+// becomeFollower moves to term, if it is newer, and follows leader, when known
+func (n *Node) becomeFollower(term uint64, leaderID string) {
+	if term > n.term {
+		n.term = term
+		n.votedFor = ""
+		n.persistState()
+	}
+	if n.role == leader {
+		close(n.leading)
+		for index, w := range n.waiters {
+			w.result <- applied{err: ErrLeadershipLost}
+			delete(n.waiters, index)
+		}
+	}
+	n.role = follower
+	n.leader = leaderID
+	n.ready = false
+}
+
+// heardFromQuorum reports whether a majority, the leader included, answered within an election timeout
+func (n *Node) heardFromQuorum(now time.Time) bool {
+	count := 0
+	for _, v := range n.membership.GetVoters() {
+		if v == n.id || now.Sub(n.acked[v]) < n.config.ElectionTimeout {
+			count++
+		}
+	}
+	return n.isQuorum(count)
+}
+
+func (n *Node) isQuorum(count int) bool {
+	return count > len(n.membership.GetVoters())/2
+}
+
+func (n *Node) isVoter(id string) bool {
+	for _, v := range n.membership.GetVoters() {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
+
+// others returns every voter but this node
+func (n *Node) others() []string {
+	var peers []string
+	for _, v := range n.membership.GetVoters() {
+		if v != n.id {
+			peers = append(peers, v)
+		}
+	}
+	return peers
+}
+
+func (n *Node) handleVote(req *pb.VoteRequest) *pb.VoteReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.halted || req.Term < n.term {
+		return &pb.VoteReply{Term: n.term}
+	}
+	//While the leader is heard from, a node that lost touch with it can't force an election
+	if n.role == leader || (n.leader != "" && time.Since(n.lastContact) < n.config.ElectionTimeout) {
+		return &pb.VoteReply{Term: n.term}
+	}
+	if req.Term > n.term {
+		n.becomeFollower(req.Term, "")
+	}
+	upToDate := req.LastLogTerm > n.lastTerm() || (req.LastLogTerm == n.lastTerm() && req.LastLogIndex >= n.lastIndex())
+	if (n.votedFor == "" || n.votedFor == req.Candidate) && upToDate {
+		n.votedFor = req.Candidate
+		n.persistState()
+		n.resetDeadline()
+		return &pb.VoteReply{Term: n.term, Granted: true}
+	}
+	return &pb.VoteReply{Term: n.term}
+}
+
+// follow makes the node a follower of the leader it just heard from
+func (n *Node) follow(term uint64, leaderID string) {
+	if term > n.term || n.role != follower || n.leader != leaderID {
+		n.becomeFollower(term, leaderID)
+	}
+	n.lastContact = time.Now()
+	n.resetDeadline()
+}
+
+func (n *Node) handleAppend(req *pb.AppendRequest) *pb.AppendReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.halted || req.Term < n.term {
+		return &pb.AppendReply{Term: n.term}
+	}
+	n.follow(req.Term, req.Leader)
+
+	prev, prevTerm, entries := req.PrevLogIndex, req.PrevLogTerm, req.Entries
+	last := req.PrevLogIndex + uint64(len(req.Entries))
+	//Whatever the snapshot covers is committed, so it matches the leader
+	if base := n.log[0].Index; prev < base {
+		for len(entries) > 0 && entries[0].Index <= base {
+			entries = entries[1:]
+		}
+		prev, prevTerm = base, n.log[0].Term
+		if last < base {
+			last = base
+		}
+	}
+	if prev > n.lastIndex() {
+		return &pb.AppendReply{Term: n.term, Index: n.lastIndex() + 1}
+	}
+	if n.termAt(prev) != prevTerm {
+		//Skip back over the whole conflicting term instead of one entry per round trip
+		conflict := n.termAt(prev)
+		index := prev
+		for index > n.log[0].Index+1 && index > n.commitIndex+1 && n.termAt(index-1) == conflict {
+			index--
+		}
+		return &pb.AppendReply{Term: n.term, Index: index}
+	}
+
+	for i, e := range entries {
+		if e.Index <= n.lastIndex() {
+			if n.termAt(e.Index) == e.Term {
+				continue
+			}
+			n.truncate(e.Index)
+		}
+		fresh := entries[i:]
+		n.persist(&pb.RaftRecord{Entries: fresh})
+		n.log = append(n.log, fresh...)
+		n.refreshMembership()
+		break
+	}
+
+	if req.LeaderCommit > n.commitIndex {
+		n.commitIndex = req.LeaderCommit
+		if last < n.commitIndex {
+			n.commitIndex = last
+		}
+		n.changed.Broadcast()
+	}
+	return &pb.AppendReply{Term: n.term, Success: true, Index: last}
+}
+
+func (n *Node) handleSnapshot(req *pb.SnapshotRequest) *pb.SnapshotReply {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.halted || req.Term < n.term {
+		return &pb.SnapshotReply{Term: n.term}
+	}
+	n.follow(req.Term, req.Leader)
+
+	received := req.Offset + uint64(len(req.Data))
+	if req.Snapshot.GetIndex() <= n.commitIndex {
+		//Nothing the node doesn't have already, take the chunk without keeping it
+		return &pb.SnapshotReply{Term: n.term, Offset: received}
+	}
+	if req.Offset == 0 {
+		n.incoming = &pb.RaftSnapshot{Index: req.Snapshot.Index, Term: req.Snapshot.Term, Membership: req.Snapshot.Membership}
+	}
+	incoming := n.incoming
+	if incoming == nil || incoming.Index != req.Snapshot.Index || incoming.Term != req.Snapshot.Term {
+		return &pb.SnapshotReply{Term: n.term}
+	}
+	if req.Offset != uint64(len(incoming.Data)) {
+		return &pb.SnapshotReply{Term: n.term, Offset: uint64(len(incoming.Data))}
+	}
+	incoming.Data = append(incoming.Data, req.Data...)
+	if !req.Done {
+		return &pb.SnapshotReply{Term: n.term, Offset: received}
+	}
+	n.incoming = nil
+
+	snapshot := incoming
+	//Keep the entries after the snapshot if the log agrees with it, otherwise start over from it
+	var keep []*pb.LogEntry
+	if snapshot.Index <= n.lastIndex() && n.termAt(snapshot.Index) == snapshot.Term {
+		keep = append(keep, n.log[snapshot.Index-n.log[0].Index+1:]...)
+	}
+	if err := n.config.Storage.SaveSnapshot(snapshot, keep); err != nil {
+		n.fail(err)
+	}
+	n.log = append([]*pb.LogEntry{{Index: snapshot.Index, Term: snapshot.Term}}, keep...)
+	n.snapshot = snapshot
+	n.commitIndex = snapshot.Index
+	n.restore = snapshot
+	n.refreshMembership()
+	n.changed.Broadcast()
+	log.Printf("raft: %s installing snapshot up to %d from %s", n.id, snapshot.Index, req.Leader)
+	return &pb.SnapshotReply{Term: n.term, Offset: received}
+}
+
+// appendEntry adds an entry of this term to the leader's log
+func (n *Node) appendEntry(kind pb.EntryType, data []byte) uint64 {
+	e := &pb.LogEntry{Term: n.term, Index: n.lastIndex() + 1, Type: kind, Data: data}
+	n.persist(&pb.RaftRecord{Entries: []*pb.LogEntry{e}})
+	n.log = append(n.log, e)
+	if kind == pb.EntryType_CONFIG {
+		n.refreshMembership()
+	}
+	n.startReplicators()
+	for _, kick := range n.replicators {
+		select {
+		case kick <- struct{}{}:
+		default:
+		}
+	}
+	return e.Index
+}
+
+// truncate drops the entries from index on, which a new leader has overwritten
+func (n *Node) truncate(index uint64) {
+	n.log = n.log[:index-n.log[0].Index]
+	for i, w := range n.waiters {
+		if i >= index {
+			w.result <- applied{err: ErrLeadershipLost}
+			delete(n.waiters, i)
+		}
+	}
+	n.refreshMembership()
+}
+
+// refreshMembership takes the membership from the latest config entry, then the snapshot, then the configured peers
+func (n *Node) refreshMembership() {
+	n.membership, n.configIndex = n.membershipAt(n.lastIndex())
+}
+
+func (n *Node) membershipAt(index uint64) (*pb.Membership, uint64) {
+	for i := index; i > n.log[0].Index; i-- {
+		e := n.entry(i)
+		if e.Type != pb.EntryType_CONFIG {
+			continue
+		}
+		membership := &pb.Membership{}
+		if err := proto.Unmarshal(e.Data, membership); err != nil {
+			n.fail(err)
+		}
+		return membership, i
+	}
+	if n.snapshot.GetMembership() != nil {
+		return n.snapshot.Membership, n.snapshot.Index
+	}
+	return &pb.Membership{Voters: n.config.Peers}, 0
+}
+
+func (n *Node) lastIndex() uint64 {
+	return n.log[len(n.log)-1].Index
+}
+
+func (n *Node) lastTerm() uint64 {
+	return n.log[len(n.log)-1].Term
+}
+
+// entry returns the entry at index, which must be after the snapshot and no later than the last
+func (n *Node) entry(index uint64) *pb.LogEntry {
+	return n.log[index-n.log[0].Index]
+}
+
+// termAt returns the term of the entry at index, 0 when it is not in the log
+func (n *Node) termAt(index uint64) uint64 {
+	if index < n.log[0].Index || index > n.lastIndex() {
+		return 0
+	}
+	return n.entry(index).Term
+}
+
+func (n *Node) persistState() {
+	n.persist(&pb.RaftRecord{State: &pb.HardState{Term: n.term, VotedFor: n.votedFor}})
+}
+
+func (n *Node) persist(rec *pb.RaftRecord) {
+	if err := n.config.Storage.Append(rec); err != nil {
+		n.fail(err)
+	}
+}
+
+// fail stops the process. A node that can't keep its log could break promises it made to the others.
+func (n *Node) fail(err error) {
+	log.Panicf("raft: %s can't go on: %v", n.id, err)
+}
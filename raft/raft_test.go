@@ -0,0 +1,377 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	pb "program/route"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memMachine keeps the commands applied to it in order
+type memMachine struct {
+	mu       sync.Mutex
+	commands []string
+}
+
+func (m *memMachine) Apply(index uint64, data []byte) interface{} {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands = append(m.commands, string(data))
+	return len(m.commands)
+}
+
+func (m *memMachine) Snapshot() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return []byte(strings.Join(m.commands, "\n")), nil
+}
+
+func (m *memMachine) Restore(data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.commands = nil
+	if len(data) > 0 {
+		m.commands = strings.Split(string(data), "\n")
+	}
+	return nil
+}
+
+func (m *memMachine) applied() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.commands...)
+}
+
+var errUnreachable = errors.New("unreachable")
+
+// cluster runs nodes in one process. They talk through memTransport, straight
+// to each other's handlers, and can be split into groups that can't reach each other.
+type cluster struct {
+	t             *testing.T
+	snapshotEvery uint64
+
+	mu       sync.Mutex
+	nodes    map[string]*Node
+	machines map[string]*memMachine
+	//Nodes only reach the nodes in the same group, all are in group 0 to begin with
+	groups map[string]int
+}
+
+// memTransport is one node's way to the others in the cluster
+type memTransport struct {
+	c    *cluster
+	from string
+}
+
+func (m memTransport) node(to string) (*Node, error) {
+	m.c.mu.Lock()
+	defer m.c.mu.Unlock()
+	n, ok := m.c.nodes[to]
+	if !ok || m.c.groups[m.from] != m.c.groups[to] {
+		return nil, errUnreachable
+	}
+	return n, nil
+}
+
+func (m memTransport) RequestVote(ctx context.Context, to string, req *pb.VoteRequest) (*pb.VoteReply, error) {
+	n, err := m.node(to)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleVote(req), nil
+}
+
+func (m memTransport) AppendEntries(ctx context.Context, to string, req *pb.AppendRequest) (*pb.AppendReply, error) {
+	n, err := m.node(to)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleAppend(req), nil
+}
+
+func (m memTransport) InstallSnapshot(ctx context.Context, to string, req *pb.SnapshotRequest) (*pb.SnapshotReply, error) {
+	n, err := m.node(to)
+	if err != nil {
+		return nil, err
+	}
+	return n.handleSnapshot(req), nil
+}
+
+// newCluster starts a node for every id, with the others as peers
+func newCluster(t *testing.T, snapshotEvery uint64, ids ...string) *cluster {
+	c := &cluster{t: t, snapshotEvery: snapshotEvery, nodes: make(map[string]*Node), machines: make(map[string]*memMachine), groups: make(map[string]int)}
+	t.Cleanup(c.stop)
+	for _, id := range ids {
+		c.start(id, ids)
+	}
+	return c
+}
+
+// start starts a node with peers as the membership to begin with
+func (c *cluster) start(id string, peers []string) *Node {
+	c.t.Helper()
+	m := &memMachine{}
+	n, err := NewNode(Config{
+		ID:                id,
+		Peers:             peers,
+		Storage:           NewMemoryStorage(),
+		Transport:         memTransport{c: c, from: id},
+		ElectionTimeout:   100 * time.Millisecond,
+		HeartbeatInterval: 20 * time.Millisecond,
+		SnapshotEvery:     c.snapshotEvery,
+	}, m)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	c.mu.Lock()
+	c.nodes[id], c.machines[id] = n, m
+	c.mu.Unlock()
+	return n
+}
+
+func (c *cluster) stop() {
+	c.mu.Lock()
+	nodes := c.nodes
+	c.nodes = nil
+	c.mu.Unlock()
+	for _, n := range nodes {
+		n.Stop()
+	}
+}
+
+func (c *cluster) node(id string) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodes[id]
+}
+
+// cutOff puts ids in a group of their own
+func (c *cluster) cutOff(ids ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, id := range ids {
+		c.groups[id] = 1
+	}
+}
+
+func (c *cluster) heal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.groups = make(map[string]int)
+}
+
+// waitFor fails the test unless done turns true within a few seconds
+func (c *cluster) waitFor(what string, done func() bool) {
+	c.t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			c.t.Fatal("timed out waiting for " + what)
+		}
+	}
+}
+
+// leader waits until exactly one of ids is a leader that serves, and returns it
+func (c *cluster) leader(ids ...string) string {
+	c.t.Helper()
+	var leader string
+	c.waitFor("a leader", func() bool {
+		leader = ""
+		for _, id := range ids {
+			if !c.node(id).IsLeader() {
+				continue
+			}
+			if leader != "" {
+				return false
+			}
+			leader = id
+		}
+		return leader != ""
+	})
+	return leader
+}
+
+func (c *cluster) propose(id, command string) {
+	c.t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, err := c.node(id).Propose(ctx, []byte(command)); err != nil {
+		c.t.Fatalf("propose %.20q on %s: %v", command, id, err)
+	}
+}
+
+// waitApplied waits until every one of ids has applied exactly want
+func (c *cluster) waitApplied(want []string, ids ...string) {
+	c.t.Helper()
+	for _, id := range ids {
+		m := c.machines[id]
+		c.waitFor(id+" to apply every command", func() bool { return reflect.DeepEqual(m.applied(), want) })
+	}
+}
+
+func TestElectsOneLeader(t *testing.T) {
+	c := newCluster(t, 0, "a", "b", "c")
+	leader := c.leader("a", "b", "c")
+	for _, id := range []string{"a", "b", "c"} {
+		n := c.node(id)
+		c.waitFor(id+" to follow "+leader, func() bool { return n.Leader() == leader })
+	}
+	c.propose(leader, "one")
+	c.waitApplied([]string{"one"}, "a", "b", "c")
+}
+
+func TestCommitsWithAMinorityCutOff(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e"}
+	c := newCluster(t, 0, ids...)
+	leader := c.leader(ids...)
+	var majority, minority []string
+	for _, id := range ids {
+		if id != leader && len(minority) < 2 {
+			minority = append(minority, id)
+		} else {
+			majority = append(majority, id)
+		}
+	}
+
+	c.cutOff(minority...)
+	c.propose(leader, "one")
+	c.propose(leader, "two")
+	c.waitApplied([]string{"one", "two"}, majority...)
+	for _, id := range minority {
+		if got := c.machines[id].applied(); len(got) != 0 {
+			t.Fatalf("%s applied %v while cut off", id, got)
+		}
+	}
+
+	//Back in touch, they catch up with whoever leads by then
+	c.heal()
+	c.waitApplied([]string{"one", "two"}, ids...)
+}
+
+func TestCutOffLeaderStepsDownAndLosesItsEntries(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	c := newCluster(t, 0, ids...)
+	old := c.leader(ids...)
+	c.propose(old, "one")
+	c.waitApplied([]string{"one"}, ids...)
+
+	//The old leader still takes the command, but no majority ever hears of it
+	c.cutOff(old)
+	lost := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_, err := c.node(old).Propose(ctx, []byte("lost"))
+		lost <- err
+	}()
+
+	var rest []string
+	for _, id := range ids {
+		if id != old {
+			rest = append(rest, id)
+		}
+	}
+	leader := c.leader(rest...)
+	c.propose(leader, "two")
+	c.waitFor(old+" to step down", func() bool { return !c.node(old).IsLeader() })
+	if err := <-lost; !errors.Is(err, ErrLeadershipLost) {
+		t.Fatalf("propose on the cut off leader: %v", err)
+	}
+
+	c.heal()
+	c.waitApplied([]string{"one", "two"}, ids...)
+	n := c.node(old)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for _, e := range n.log[1:] {
+		if string(e.Data) == "lost" {
+			t.Fatalf("%s kept the entry it never committed at %d", old, e.Index)
+		}
+	}
+}
+
+func TestSnapshotCatchesUpANodeThatWasAway(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	c := newCluster(t, 4, ids...)
+	leader := c.leader(ids...)
+	away := ids[0]
+	if away == leader {
+		away = ids[1]
+	}
+
+	c.cutOff(away)
+	//Big enough that the snapshot goes in more than one chunk
+	want := []string{strings.Repeat("x", 3*snapshotChunk/2)}
+	c.propose(leader, want[0])
+	for _, command := range []string{"two", "three", "four", "five", "six"} {
+		c.propose(leader, command)
+		want = append(want, command)
+	}
+	n := c.node(leader)
+	c.waitFor("a snapshot on "+leader, func() bool {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		return n.log[0].Index > 0
+	})
+
+	c.heal()
+	c.waitApplied(want, ids...)
+	n = c.node(away)
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.snapshot == nil {
+		t.Fatalf("%s caught up without a snapshot", away)
+	}
+}
+
+func TestMembershipChange(t *testing.T) {
+	ids := []string{"a", "b", "c"}
+	c := newCluster(t, 0, ids...)
+	leader := c.leader(ids...)
+	c.propose(leader, "one")
+
+	//A new node starts with no membership and waits to be added
+	c.start("d", nil)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	membership, err := c.node(leader).ChangeMembership(ctx, "d", false)
+	if err != nil {
+		t.Fatalf("add d: %v", err)
+	}
+	if len(membership.Voters) != 4 {
+		t.Fatalf("membership after adding d: %v", membership.Voters)
+	}
+	c.propose(leader, "two")
+	c.waitApplied([]string{"one", "two"}, "a", "b", "c", "d")
+	d := c.node("d")
+	c.waitFor("d to know it is a voter", func() bool { return len(d.Membership()) == 4 })
+
+	//Without one of the first three the rest still make a majority of the new membership
+	var removed string
+	for _, id := range ids {
+		if id != leader {
+			removed = id
+			break
+		}
+	}
+	if _, err := c.node(leader).ChangeMembership(ctx, removed, true); err != nil {
+		t.Fatalf("remove %s: %v", removed, err)
+	}
+	c.node(removed).Stop()
+	c.propose(leader, "three")
+	var rest []string
+	for _, id := range []string{"a", "b", "c", "d"} {
+		if id != removed {
+			rest = append(rest, id)
+		}
+	}
+	c.waitApplied([]string{"one", "two", "three"}, rest...)
+	for _, id := range rest {
+		if got := c.node(id).Membership(); len(got) != 3 {
+			t.Fatalf("%s has membership %v after removing %s", id, got, removed)
+		}
+	}
+}
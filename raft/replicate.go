@@ -0,0 +1,332 @@
+package raft
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"log"
+	pb "program/route"
+	"time"
+)
+
+// startReplicators gives every voter that doesn't have one yet a goroutine
+// that keeps it up to date. Call as leader.
+func (n *Node) startReplicators() {
+	if n.role != leader {
+		return
+	}
+	for _, peer := range n.others() {
+		if _, ok := n.replicators[peer]; ok {
+			continue
+		}
+		kick := make(chan struct{}, 1)
+		n.replicators[peer] = kick
+		n.next[peer] = n.lastIndex() + 1
+		n.match[peer] = 0
+		//Give new followers an election timeout to answer before they count as lost
+		n.acked[peer] = time.Now()
+		n.running.Add(1)
+		go n.replicate(peer, n.leading, kick)
+	}
+}
+
+// replicate sends the peer what it is missing, or a heartbeat when it is missing nothing,
+// until the term ends or the peer has learnt that it was removed
+func (n *Node) replicate(peer string, leading chan struct{}, kick chan struct{}) {
+	defer n.running.Done()
+	defer func() {
+		n.mu.Lock()
+		if n.replicators[peer] == kick {
+			delete(n.replicators, peer)
+		}
+		n.mu.Unlock()
+	}()
+	ticker := time.NewTicker(n.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		more, keep := n.sendTo(peer, leading)
+		if !keep {
+			return
+		}
+		if more {
+			continue
+		}
+		select {
+		case <-leading:
+			return
+		case <-n.stopped:
+			return
+		case <-kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendTo makes one AppendEntries or InstallSnapshot call to the peer. It reports
+// whether there is more to send straight away, and whether to keep replicating.
+func (n *Node) sendTo(peer string, leading chan struct{}) (more, keep bool) {
+	n.mu.Lock()
+	if n.role != leader || n.leading != leading {
+		n.mu.Unlock()
+		return false, false
+	}
+	term := n.term
+	next := n.next[peer]
+
+	//The follower is so far behind that the entries it needs are gone, send the snapshot instead
+	if next <= n.log[0].Index {
+		snapshot := n.snapshot
+		n.mu.Unlock()
+
+		reply, err := n.sendSnapshot(peer, term, snapshot, leading)
+
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		if err != nil {
+			return false, true
+		}
+		if !n.stillLeading(term, reply.Term) {
+			return false, false
+		}
+		n.acked[peer] = time.Now()
+		if snapshot.Index > n.match[peer] {
+			n.match[peer] = snapshot.Index
+		}
+		n.next[peer] = n.match[peer] + 1
+		n.maybeCommit()
+		return true, true
+	}
+
+	req := &pb.AppendRequest{
+		Term:         term,
+		Leader:       n.id,
+		PrevLogIndex: next - 1,
+		PrevLogTerm:  n.termAt(next - 1),
+		Entries:      n.batch(next),
+		LeaderCommit: n.commitIndex,
+	}
+	n.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+	reply, err := n.config.Transport.AppendEntries(ctx, peer, req)
+	cancel()
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if err != nil {
+		return false, true
+	}
+	if !n.stillLeading(term, reply.Term) {
+		return false, false
+	}
+	n.acked[peer] = time.Now()
+	if !reply.Success {
+		//Back up to where the follower says its log ends or stops agreeing
+		if reply.Index > 0 && reply.Index < next {
+			n.next[peer] = reply.Index
+		} else if next > 1 {
+			n.next[peer] = next - 1
+		}
+		return true, true
+	}
+	if reply.Index > n.match[peer] {
+		n.match[peer] = reply.Index
+	}
+	n.next[peer] = n.match[peer] + 1
+	n.maybeCommit()
+
+	//A removed peer is kept up to date until it has the entry that removed it
+	keep = n.isVoter(peer) || n.match[peer] < n.configIndex
+	return keep && n.next[peer] <= n.lastIndex(), keep
+}
+
+// sendSnapshot sends the snapshot to the peer in chunks, one after another. It
+// returns the reply to the last chunk, or an error when the peer lost track of the
+// chunks or couldn't be reached. The next try starts over from the beginning.
+func (n *Node) sendSnapshot(peer string, term uint64, snapshot *pb.RaftSnapshot, leading chan struct{}) (*pb.SnapshotReply, error) {
+	header := &pb.RaftSnapshot{Index: snapshot.Index, Term: snapshot.Term, Membership: snapshot.Membership}
+	data := snapshot.Data
+	for offset := 0; ; {
+		end := offset + snapshotChunk
+		if end > len(data) {
+			end = len(data)
+		}
+		req := &pb.SnapshotRequest{Term: term, Leader: n.id, Snapshot: header, Offset: uint64(offset), Data: data[offset:end], Done: end == len(data)}
+		ctx, cancel := context.WithTimeout(context.Background(), n.config.ElectionTimeout)
+		reply, err := n.config.Transport.InstallSnapshot(ctx, peer, req)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		if reply.Term > term || req.Done && reply.Offset == uint64(end) {
+			return reply, nil
+		}
+		if reply.Offset != uint64(end) {
+			return nil, errors.New("raft: " + peer + " lost track of the snapshot")
+		}
+		select {
+		case <-leading:
+			return nil, ErrLeadershipLost
+		case <-n.stopped:
+			return nil, ErrStopped
+		default:
+		}
+		offset = end
+	}
+}
+
+// stillLeading steps down if the reply came from a later term, and reports whether
+// the node is still leader of term
+func (n *Node) stillLeading(term, replyTerm uint64) bool {
+	if replyTerm > n.term {
+		n.becomeFollower(replyTerm, "")
+		return false
+	}
+	return n.role == leader && n.term == term
+}
+
+// batch returns the entries from index on, as many as fit in one request
+func (n *Node) batch(index uint64) []*pb.LogEntry {
+	var entries []*pb.LogEntry
+	size := 0
+	for i := index; i <= n.lastIndex() && len(entries) < maxBatch; i++ {
+		e := n.entry(i)
+		size += len(e.Data)
+		if len(entries) > 0 && size > maxBatchBytes {
+			break
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+// maybeCommit advances the commit index to the newest entry of this term a majority holds
+func (n *Node) maybeCommit() {
+	if n.role != leader {
+		return
+	}
+	for index := n.lastIndex(); index > n.commitIndex; index-- {
+		//Entries from earlier terms are only committed along with a later one
+		if n.termAt(index) != n.term {
+			return
+		}
+		count := 0
+		for _, v := range n.membership.GetVoters() {
+			if v == n.id || n.match[v] >= index {
+				count++
+			}
+		}
+		if n.isQuorum(count) {
+			n.commitIndex = index
+			n.changed.Broadcast()
+			return
+		}
+	}
+}
+
+// applyLoop feeds committed entries to the state machine in order, and hands
+// the results to whoever proposed them
+func (n *Node) applyLoop() {
+	defer n.running.Done()
+	for {
+		n.mu.Lock()
+		for !n.halted && n.restore == nil && n.lastApplied >= n.commitIndex {
+			n.changed.Wait()
+		}
+		if n.halted {
+			n.mu.Unlock()
+			return
+		}
+		if snapshot := n.restore; snapshot != nil {
+			n.restore = nil
+			n.mu.Unlock()
+			if err := n.sm.Restore(snapshot.Data); err != nil {
+				n.fail(err)
+			}
+			n.mu.Lock()
+			n.lastApplied = snapshot.Index
+			n.mu.Unlock()
+			continue
+		}
+		var entries []*pb.LogEntry
+		for i := n.lastApplied + 1; i <= n.commitIndex; i++ {
+			entries = append(entries, n.entry(i))
+		}
+		n.mu.Unlock()
+
+		for _, e := range entries {
+			var value interface{}
+			if e.Type == pb.EntryType_COMMAND {
+				value = n.sm.Apply(e.Index, e.Data)
+			}
+			if !n.markApplied(e, value) {
+				break
+			}
+		}
+		n.maybeSnapshot()
+	}
+}
+
+// markApplied records that e has been applied. It reports false when a snapshot
+// from the leader has overtaken the rest of the batch.
+func (n *Node) markApplied(e *pb.LogEntry, value interface{}) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.restore != nil {
+		return false
+	}
+	n.lastApplied = e.Index
+	if w, ok := n.waiters[e.Index]; ok {
+		delete(n.waiters, e.Index)
+		if w.term == e.Term {
+			w.result <- applied{value: value}
+		} else {
+			w.result <- applied{err: ErrLeadershipLost}
+		}
+	}
+	if n.role == leader && e.Index == n.noop && e.Term == n.term {
+		n.ready = true
+	}
+	//A leader that removed itself hands over once the removal is committed
+	if n.role == leader && e.Index == n.configIndex && !n.isVoter(n.id) {
+		log.Println("raft: " + n.id + " was removed from the cluster, stepping down")
+		n.becomeFollower(n.term, "")
+	}
+	return true
+}
+
+// maybeSnapshot compacts the log once enough entries have been applied since the last snapshot.
+// Only the apply loop calls it, so the state machine is exactly at lastApplied.
+func (n *Node) maybeSnapshot() {
+	if n.config.SnapshotEvery == 0 {
+		return
+	}
+	n.mu.Lock()
+	index := n.lastApplied
+	due := n.restore == nil && index-n.log[0].Index >= n.config.SnapshotEvery
+	n.mu.Unlock()
+	if !due {
+		return
+	}
+
+	data, err := n.sm.Snapshot()
+	if err != nil {
+		log.Printf("raft: %s could not take a snapshot: %v", n.id, err)
+		return
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.restore != nil || index <= n.log[0].Index {
+		return
+	}
+	membership, _ := n.membershipAt(index)
+	snapshot := &pb.RaftSnapshot{Index: index, Term: n.termAt(index), Membership: proto.Clone(membership).(*pb.Membership), Data: data}
+	keep := append([]*pb.LogEntry(nil), n.log[index-n.log[0].Index+1:]...)
+	if err := n.config.Storage.SaveSnapshot(snapshot, keep); err != nil {
+		n.fail(err)
+	}
+	n.log = append([]*pb.LogEntry{{Index: snapshot.Index, Term: snapshot.Term}}, keep...)
+	n.snapshot = snapshot
+	log.Printf("raft: %s took a snapshot up to %d", n.id, index)
+}
@@ -0,0 +1,286 @@
+package raft
+
+import (
+	"errors"
+	"google.golang.org/protobuf/proto"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	pb "program/route"
+	"program/wal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Storage keeps what a node must not lose in a crash: its term and vote, its
+// log and its latest snapshot. Writes must be durable before they return.
+type Storage interface {
+	// Load returns everything saved so far. The entries follow on from the snapshot's index.
+	Load() (*pb.HardState, *pb.RaftSnapshot, []*pb.LogEntry, error)
+	// Append saves the hard state, when it is set, and the entries, which replace
+	// any saved at or after the first one's index
+	Append(rec *pb.RaftRecord) error
+	// SaveSnapshot replaces the whole log with the snapshot and the entries after it
+	SaveSnapshot(snapshot *pb.RaftSnapshot, entries []*pb.LogEntry) error
+}
+
+// splice adds entries to the log, dropping whatever it held from the first new index on
+func splice(log, entries []*pb.LogEntry) []*pb.LogEntry {
+	if len(entries) == 0 {
+		return log
+	}
+	i := sort.Search(len(log), func(i int) bool { return log[i].Index >= entries[0].Index })
+	return append(log[:i], entries...)
+}
+
+// MemoryStorage keeps everything in memory, for a node that may forget it all
+// when the process exits. It is safe for concurrent use.
+type MemoryStorage struct {
+	mu       sync.Mutex
+	state    *pb.HardState
+	snapshot *pb.RaftSnapshot
+	entries  []*pb.LogEntry
+}
+
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{}
+}
+
+func (m *MemoryStorage) Load() (*pb.HardState, *pb.RaftSnapshot, []*pb.LogEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state, m.snapshot, append([]*pb.LogEntry(nil), m.entries...), nil
+}
+
+func (m *MemoryStorage) Append(rec *pb.RaftRecord) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if rec.State != nil {
+		m.state = rec.State
+	}
+	m.entries = splice(m.entries, rec.Entries)
+	return nil
+}
+
+func (m *MemoryStorage) SaveSnapshot(snapshot *pb.RaftSnapshot, entries []*pb.LogEntry) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshot = snapshot
+	m.entries = append([]*pb.LogEntry(nil), entries...)
+	return nil
+}
+
+// FileStorage keeps the snapshot in dir/snapshot and the log after it in a
+// write-ahead log in dir/log-N. Taking a snapshot writes the entries that
+// remain to a fresh log-N+1 and deletes the old one.
+type FileStorage struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	generation  uint64
+	log         *wal.Log
+	//The last hard state saved, carried over to each new generation
+	state *pb.HardState
+}
+
+const logPrefix = "log-"
+
+// OpenFileStorage opens or creates the storage in dir. A new log segment is
+// started once the current one would grow past segmentSize bytes.
+func OpenFileStorage(dir string, segmentSize int64) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	//Only the newest complete generation counts, the others are left over from an interrupted compaction
+	var generations []uint64
+	for _, info := range names {
+		name := info.Name()
+		if !strings.HasPrefix(name, logPrefix) {
+			continue
+		}
+		if strings.HasSuffix(name, ".tmp") {
+			if err := os.RemoveAll(filepath.Join(dir, name)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if g, err := strconv.ParseUint(strings.TrimPrefix(name, logPrefix), 10, 64); err == nil {
+			generations = append(generations, g)
+		}
+	}
+	sort.Slice(generations, func(i, j int) bool { return generations[i] < generations[j] })
+	f := &FileStorage{dir: dir, segmentSize: segmentSize}
+	if len(generations) > 0 {
+		f.generation = generations[len(generations)-1]
+		for _, g := range generations[:len(generations)-1] {
+			if err := os.RemoveAll(f.logDir(g)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	f.log, err = wal.Open(f.logDir(f.generation), segmentSize)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *FileStorage) logDir(generation uint64) string {
+	return filepath.Join(f.dir, logPrefix+strconv.FormatUint(generation, 10))
+}
+
+func (f *FileStorage) Load() (*pb.HardState, *pb.RaftSnapshot, []*pb.LogEntry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var snapshot *pb.RaftSnapshot
+	data, err := ioutil.ReadFile(filepath.Join(f.dir, "snapshot"))
+	switch {
+	case err == nil:
+		snapshot = &pb.RaftSnapshot{}
+		if err := proto.Unmarshal(data, snapshot); err != nil {
+			return nil, nil, nil, errors.New("snapshot: " + err.Error())
+		}
+	case !os.IsNotExist(err):
+		return nil, nil, nil, err
+	}
+
+	var entries []*pb.LogEntry
+	err = f.log.Replay(func(data []byte) error {
+		rec := &pb.RaftRecord{}
+		if err := proto.Unmarshal(data, rec); err != nil {
+			return err
+		}
+		if rec.State != nil {
+			f.state = rec.State
+		}
+		entries = splice(entries, rec.Entries)
+		return nil
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	//A crash between writing the snapshot and the new generation leaves entries it covers
+	if snapshot != nil {
+		i := sort.Search(len(entries), func(i int) bool { return entries[i].Index > snapshot.Index })
+		entries = entries[i:]
+	}
+	return f.state, snapshot, entries, nil
+}
+
+func (f *FileStorage) Append(rec *pb.RaftRecord) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := appendRecord(f.log, rec); err != nil {
+		return err
+	}
+	if rec.State != nil {
+		f.state = rec.State
+	}
+	return nil
+}
+
+// appendRecord writes rec, split over several records if it is too big for one
+func appendRecord(log *wal.Log, rec *pb.RaftRecord) error {
+	data, err := proto.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	if len(data) <= wal.MaxRecordSize || len(rec.Entries) < 2 {
+		return log.Append(data)
+	}
+	half := len(rec.Entries) / 2
+	if err := appendRecord(log, &pb.RaftRecord{State: rec.State, Entries: rec.Entries[:half]}); err != nil {
+		return err
+	}
+	return appendRecord(log, &pb.RaftRecord{Entries: rec.Entries[half:]})
+}
+
+func (f *FileStorage) SaveSnapshot(snapshot *pb.RaftSnapshot, entries []*pb.LogEntry) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := proto.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err := writeFile(filepath.Join(f.dir, "snapshot"), data); err != nil {
+		return err
+	}
+
+	//Build the next generation under a temporary name so a crash can't leave it half written
+	next := f.generation + 1
+	tmp := f.logDir(next) + ".tmp"
+	if err := os.RemoveAll(tmp); err != nil {
+		return err
+	}
+	fresh, err := wal.Open(tmp, f.segmentSize)
+	if err != nil {
+		return err
+	}
+	if err := appendRecord(fresh, &pb.RaftRecord{State: f.state, Entries: entries}); err != nil {
+		fresh.Close()
+		return err
+	}
+	if err := fresh.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.logDir(next)); err != nil {
+		return err
+	}
+	if err := syncDir(f.dir); err != nil {
+		return err
+	}
+
+	f.log.Close()
+	os.RemoveAll(f.logDir(f.generation))
+	f.generation = next
+	f.log, err = wal.Open(f.logDir(next), f.segmentSize)
+	return err
+}
+
+// Close closes the log
+func (f *FileStorage) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.log.Close()
+}
+
+// writeFile replaces path with data in one step
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
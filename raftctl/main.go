@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"log"
+	"program/certs"
+	"program/raft"
+	pb "program/route"
+	"strings"
+	"time"
+)
+
+// raftctl adds servers to a running cluster and removes them, through any of its servers.
+// The servers only take the change from one of their own, so it presents a server
+// certificate of the cluster:
+//
+//	go run ./raftctl -server localhost:5000 -add localhost:5003 -tls-cert tls/server.pem -tls-key tls/server-key.pem -tls-ca tls/ca.pem
+//	go run ./raftctl -server localhost:5000 -remove localhost:5001 -tls-cert tls/server.pem -tls-key tls/server-key.pem -tls-ca tls/ca.pem
+func main() {
+	server := flag.String("server", "localhost:5000", "Address of any server of the cluster")
+	add := flag.String("add", "", "Address of a server to add as a voting member")
+	remove := flag.String("remove", "", "Address of a server to remove")
+	certFile := flag.String("tls-cert", "", "Server certificate of the cluster to present")
+	keyFile := flag.String("tls-key", "", "Key of the certificate")
+	caFile := flag.String("tls-ca", "", "CA the servers' certificates are signed by")
+	timeout := flag.Duration("timeout", 10*time.Second, "How long to keep trying")
+	flag.Parse()
+	if (*add == "") == (*remove == "") {
+		log.Fatalf("give either -add or -remove")
+	}
+
+	dial := grpc.WithInsecure()
+	if *certFile != "" || *caFile != "" {
+		config, err := certs.ClientConfig(*certFile, *keyFile, *caFile)
+		if err != nil {
+			log.Fatalf("failed to load certificates: %v", err)
+		}
+		dial = grpc.WithTransportCredentials(credentials.NewTLS(config))
+	}
+	transport := raft.NewGRPCTransport(dial)
+	defer transport.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	var membership *pb.Membership
+	var err error
+	if *add != "" {
+		membership, err = transport.Join(ctx, *server, *add)
+	} else {
+		membership, err = transport.Leave(ctx, *server, *remove)
+	}
+	if err != nil {
+		log.Fatalf("could not change the membership: %v", err)
+	}
+	log.Println("Members: " + strings.Join(membership.Voters, ", "))
+}
@@ -0,0 +1,263 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.17.3
+// source: route/peer.proto
+
+package program
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// PeerClient is the client API for Peer service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type PeerClient interface {
+	//Bully: a server that notices the coordinator is gone asks every server with a higher id to take over.
+	//Any that is alive answers and holds an election of its own
+	Elect(ctx context.Context, in *Election, opts ...grpc.CallOption) (*Answer, error)
+	//The winner tells everyone else it is the coordinator
+	Announce(ctx context.Context, in *Coordinator, opts ...grpc.CallOption) (*Answer, error)
+	//The others check now and then that the coordinator is still there
+	Alive(ctx context.Context, in *Ping, opts ...grpc.CallOption) (*Answer, error)
+	//Ring: the election message goes round the ring of servers in order of id,
+	//carrying the highest id it has met
+	RingElection(ctx context.Context, in *RingToken, opts ...grpc.CallOption) (*Answer, error)
+	//Once it comes back to the server it names, that server has won and sends the result round
+	RingElected(ctx context.Context, in *RingToken, opts ...grpc.CallOption) (*Answer, error)
+}
+
+type peerClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewPeerClient(cc grpc.ClientConnInterface) PeerClient {
+	return &peerClient{cc}
+}
+
+func (c *peerClient) Elect(ctx context.Context, in *Election, opts ...grpc.CallOption) (*Answer, error) {
+	out := new(Answer)
+	err := c.cc.Invoke(ctx, "/Peer/Elect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerClient) Announce(ctx context.Context, in *Coordinator, opts ...grpc.CallOption) (*Answer, error) {
+	out := new(Answer)
+	err := c.cc.Invoke(ctx, "/Peer/Announce", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerClient) Alive(ctx context.Context, in *Ping, opts ...grpc.CallOption) (*Answer, error) {
+	out := new(Answer)
+	err := c.cc.Invoke(ctx, "/Peer/Alive", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerClient) RingElection(ctx context.Context, in *RingToken, opts ...grpc.CallOption) (*Answer, error) {
+	out := new(Answer)
+	err := c.cc.Invoke(ctx, "/Peer/RingElection", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *peerClient) RingElected(ctx context.Context, in *RingToken, opts ...grpc.CallOption) (*Answer, error) {
+	out := new(Answer)
+	err := c.cc.Invoke(ctx, "/Peer/RingElected", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// PeerServer is the server API for Peer service.
+// All implementations must embed UnimplementedPeerServer
+// for forward compatibility
+type PeerServer interface {
+	//Bully: a server that notices the coordinator is gone asks every server with a higher id to take over.
+	//Any that is alive answers and holds an election of its own
+	Elect(context.Context, *Election) (*Answer, error)
+	//The winner tells everyone else it is the coordinator
+	Announce(context.Context, *Coordinator) (*Answer, error)
+	//The others check now and then that the coordinator is still there
+	Alive(context.Context, *Ping) (*Answer, error)
+	//Ring: the election message goes round the ring of servers in order of id,
+	//carrying the highest id it has met
+	RingElection(context.Context, *RingToken) (*Answer, error)
+	//Once it comes back to the server it names, that server has won and sends the result round
+	RingElected(context.Context, *RingToken) (*Answer, error)
+	mustEmbedUnimplementedPeerServer()
+}
+
+// UnimplementedPeerServer must be embedded to have forward compatible implementations.
+type UnimplementedPeerServer struct {
+}
+
+func (UnimplementedPeerServer) Elect(context.Context, *Election) (*Answer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Elect not implemented")
+}
+func (UnimplementedPeerServer) Announce(context.Context, *Coordinator) (*Answer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Announce not implemented")
+}
+func (UnimplementedPeerServer) Alive(context.Context, *Ping) (*Answer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Alive not implemented")
+}
+func (UnimplementedPeerServer) RingElection(context.Context, *RingToken) (*Answer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RingElection not implemented")
+}
+func (UnimplementedPeerServer) RingElected(context.Context, *RingToken) (*Answer, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RingElected not implemented")
+}
+func (UnimplementedPeerServer) mustEmbedUnimplementedPeerServer() {}
+
+// UnsafePeerServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to PeerServer will
+// result in compilation errors.
+type UnsafePeerServer interface {
+	mustEmbedUnimplementedPeerServer()
+}
+
+func RegisterPeerServer(s grpc.ServiceRegistrar, srv PeerServer) {
+	s.RegisterService(&Peer_ServiceDesc, srv)
+}
+
+func _Peer_Elect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Election)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerServer).Elect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Peer/Elect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerServer).Elect(ctx, req.(*Election))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Peer_Announce_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Coordinator)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerServer).Announce(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Peer/Announce",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerServer).Announce(ctx, req.(*Coordinator))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Peer_Alive_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Ping)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerServer).Alive(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Peer/Alive",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerServer).Alive(ctx, req.(*Ping))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Peer_RingElection_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RingToken)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerServer).RingElection(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Peer/RingElection",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerServer).RingElection(ctx, req.(*RingToken))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Peer_RingElected_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RingToken)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(PeerServer).RingElected(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Peer/RingElected",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(PeerServer).RingElected(ctx, req.(*RingToken))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+// Peer_ServiceDesc is the grpc.ServiceDesc for Peer service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Peer_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "Peer",
+	HandlerType: (*PeerServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Elect",
+			Handler:    _Peer_Elect_Handler,
+		},
+		{
+			MethodName: "Announce",
+			Handler:    _Peer_Announce_Handler,
+		},
+		{
+			MethodName: "Alive",
+			Handler:    _Peer_Alive_Handler,
+		},
+		{
+			MethodName: "RingElection",
+			Handler:    _Peer_RingElection_Handler,
+		},
+		{
+			MethodName: "RingElected",
+			Handler:    _Peer_RingElected_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "route/peer.proto",
+}
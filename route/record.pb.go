@@ -0,0 +1,537 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        v3.17.3
+// source: route/record.proto
+
+package program
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// A change to the server's state, written to the write-ahead log or replicated with Raft before it is applied
+type Record struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Types that are assignable to Op:
+	//	*Record_Connect
+	//	*Record_Disconnect
+	//	*Record_Broadcast
+	//	*Record_JoinRoom
+	//	*Record_LeaveRoom
+	//	*Record_Rename
+	Op isRecord_Op `protobuf_oneof:"op"`
+	//Only set on broadcasts a client may send again: the request that made them,
+	//and when the server took it in Unix milliseconds
+	Request *RequestId `protobuf:"bytes,7,opt,name=request,proto3" json:"request,omitempty"`
+	Time    int64      `protobuf:"varint,8,opt,name=time,proto3" json:"time,omitempty"`
+	//Set on a disconnect the client asked for. Only then are the requests it sent
+	//forgotten, a client that was evicted may still retry them
+	Goodbye bool `protobuf:"varint,9,opt,name=goodbye,proto3" json:"goodbye,omitempty"`
+}
+
+func (x *Record) Reset() {
+	*x = Record{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_route_record_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Record) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Record) ProtoMessage() {}
+
+func (x *Record) ProtoReflect() protoreflect.Message {
+	mi := &file_route_record_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Record.ProtoReflect.Descriptor instead.
+func (*Record) Descriptor() ([]byte, []int) {
+	return file_route_record_proto_rawDescGZIP(), []int{0}
+}
+
+func (m *Record) GetOp() isRecord_Op {
+	if m != nil {
+		return m.Op
+	}
+	return nil
+}
+
+func (x *Record) GetConnect() *ConnectRequest {
+	if x, ok := x.GetOp().(*Record_Connect); ok {
+		return x.Connect
+	}
+	return nil
+}
+
+func (x *Record) GetDisconnect() *Client {
+	if x, ok := x.GetOp().(*Record_Disconnect); ok {
+		return x.Disconnect
+	}
+	return nil
+}
+
+func (x *Record) GetBroadcast() *GenericText {
+	if x, ok := x.GetOp().(*Record_Broadcast); ok {
+		return x.Broadcast
+	}
+	return nil
+}
+
+func (x *Record) GetJoinRoom() *RoomRequest {
+	if x, ok := x.GetOp().(*Record_JoinRoom); ok {
+		return x.JoinRoom
+	}
+	return nil
+}
+
+func (x *Record) GetLeaveRoom() *RoomRequest {
+	if x, ok := x.GetOp().(*Record_LeaveRoom); ok {
+		return x.LeaveRoom
+	}
+	return nil
+}
+
+func (x *Record) GetRename() *RenameRequest {
+	if x, ok := x.GetOp().(*Record_Rename); ok {
+		return x.Rename
+	}
+	return nil
+}
+
+func (x *Record) GetRequest() *RequestId {
+	if x != nil {
+		return x.Request
+	}
+	return nil
+}
+
+func (x *Record) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *Record) GetGoodbye() bool {
+	if x != nil {
+		return x.Goodbye
+	}
+	return false
+}
+
+type isRecord_Op interface {
+	isRecord_Op()
+}
+
+type Record_Connect struct {
+	Connect *ConnectRequest `protobuf:"bytes,1,opt,name=connect,proto3,oneof"`
+}
+
+type Record_Disconnect struct {
+	Disconnect *Client `protobuf:"bytes,2,opt,name=disconnect,proto3,oneof"`
+}
+
+type Record_Broadcast struct {
+	Broadcast *GenericText `protobuf:"bytes,3,opt,name=broadcast,proto3,oneof"`
+}
+
+type Record_JoinRoom struct {
+	JoinRoom *RoomRequest `protobuf:"bytes,4,opt,name=join_room,json=joinRoom,proto3,oneof"`
+}
+
+type Record_LeaveRoom struct {
+	LeaveRoom *RoomRequest `protobuf:"bytes,5,opt,name=leave_room,json=leaveRoom,proto3,oneof"`
+}
+
+type Record_Rename struct {
+	Rename *RenameRequest `protobuf:"bytes,6,opt,name=rename,proto3,oneof"`
+}
+
+func (*Record_Connect) isRecord_Op() {}
+
+func (*Record_Disconnect) isRecord_Op() {}
+
+func (*Record_Broadcast) isRecord_Op() {}
+
+func (*Record_JoinRoom) isRecord_Op() {}
+
+func (*Record_LeaveRoom) isRecord_Op() {}
+
+func (*Record_Rename) isRecord_Op() {}
+
+// A request the server carried out, remembered for a while so that a retry of it
+// gets the first answer instead of being carried out again
+type Answered struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Id        *RequestId   `protobuf:"bytes,1,opt,name=id,proto3" json:"id,omitempty"`
+	Time      int64        `protobuf:"varint,2,opt,name=time,proto3" json:"time,omitempty"`
+	Broadcast *GenericText `protobuf:"bytes,3,opt,name=broadcast,proto3" json:"broadcast,omitempty"`
+}
+
+func (x *Answered) Reset() {
+	*x = Answered{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_route_record_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Answered) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Answered) ProtoMessage() {}
+
+func (x *Answered) ProtoReflect() protoreflect.Message {
+	mi := &file_route_record_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Answered.ProtoReflect.Descriptor instead.
+func (*Answered) Descriptor() ([]byte, []int) {
+	return file_route_record_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *Answered) GetId() *RequestId {
+	if x != nil {
+		return x.Id
+	}
+	return nil
+}
+
+func (x *Answered) GetTime() int64 {
+	if x != nil {
+		return x.Time
+	}
+	return 0
+}
+
+func (x *Answered) GetBroadcast() *GenericText {
+	if x != nil {
+		return x.Broadcast
+	}
+	return nil
+}
+
+// Everything a server knows, as a Raft snapshot holds it
+type State struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	//Every connected client with its session token
+	Clients []*ConnectRequest `protobuf:"bytes,1,rep,name=clients,proto3" json:"clients,omitempty"`
+	Rooms   []*Room           `protobuf:"bytes,2,rep,name=rooms,proto3" json:"rooms,omitempty"`
+	//Sequence number of the last broadcast, and every broadcast so far
+	Seq      uint64         `protobuf:"varint,3,opt,name=seq,proto3" json:"seq,omitempty"`
+	History  []*GenericText `protobuf:"bytes,4,rep,name=history,proto3" json:"history,omitempty"`
+	LastId   int64          `protobuf:"varint,5,opt,name=last_id,json=lastId,proto3" json:"last_id,omitempty"`
+	Lamport  uint64         `protobuf:"varint,6,opt,name=lamport,proto3" json:"lamport,omitempty"`
+	Answered []*Answered    `protobuf:"bytes,7,rep,name=answered,proto3" json:"answered,omitempty"`
+	//Vector clock of the lobby messages broadcast so far
+	Lobby map[int64]uint64 `protobuf:"bytes,8,rep,name=lobby,proto3" json:"lobby,omitempty" protobuf_key:"varint,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+}
+
+func (x *State) Reset() {
+	*x = State{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_route_record_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *State) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*State) ProtoMessage() {}
+
+func (x *State) ProtoReflect() protoreflect.Message {
+	mi := &file_route_record_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use State.ProtoReflect.Descriptor instead.
+func (*State) Descriptor() ([]byte, []int) {
+	return file_route_record_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *State) GetClients() []*ConnectRequest {
+	if x != nil {
+		return x.Clients
+	}
+	return nil
+}
+
+func (x *State) GetRooms() []*Room {
+	if x != nil {
+		return x.Rooms
+	}
+	return nil
+}
+
+func (x *State) GetSeq() uint64 {
+	if x != nil {
+		return x.Seq
+	}
+	return 0
+}
+
+func (x *State) GetHistory() []*GenericText {
+	if x != nil {
+		return x.History
+	}
+	return nil
+}
+
+func (x *State) GetLastId() int64 {
+	if x != nil {
+		return x.LastId
+	}
+	return 0
+}
+
+func (x *State) GetLamport() uint64 {
+	if x != nil {
+		return x.Lamport
+	}
+	return 0
+}
+
+func (x *State) GetAnswered() []*Answered {
+	if x != nil {
+		return x.Answered
+	}
+	return nil
+}
+
+func (x *State) GetLobby() map[int64]uint64 {
+	if x != nil {
+		return x.Lobby
+	}
+	return nil
+}
+
+var File_route_record_proto protoreflect.FileDescriptor
+
+var file_route_record_proto_rawDesc = []byte{
+	0x0a, 0x12, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x2f, 0x72, 0x65, 0x63, 0x6f, 0x72, 0x64, 0x2e, 0x70,
+	0x72, 0x6f, 0x74, 0x6f, 0x1a, 0x11, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x2f, 0x72, 0x6f, 0x75, 0x74,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xee, 0x02, 0x0a, 0x06, 0x52, 0x65, 0x63, 0x6f,
+	0x72, 0x64, 0x12, 0x2b, 0x0a, 0x07, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x43, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x48, 0x00, 0x52, 0x07, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x12,
+	0x29, 0x0a, 0x0a, 0x64, 0x69, 0x73, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x07, 0x2e, 0x43, 0x6c, 0x69, 0x65, 0x6e, 0x74, 0x48, 0x00, 0x52, 0x0a,
+	0x64, 0x69, 0x73, 0x63, 0x6f, 0x6e, 0x6e, 0x65, 0x63, 0x74, 0x12, 0x2c, 0x0a, 0x09, 0x62, 0x72,
+	0x6f, 0x61, 0x64, 0x63, 0x61, 0x73, 0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e,
+	0x47, 0x65, 0x6e, 0x65, 0x72, 0x69, 0x63, 0x54, 0x65, 0x78, 0x74, 0x48, 0x00, 0x52, 0x09, 0x62,
+	0x72, 0x6f, 0x61, 0x64, 0x63, 0x61, 0x73, 0x74, 0x12, 0x2b, 0x0a, 0x09, 0x6a, 0x6f, 0x69, 0x6e,
+	0x5f, 0x72, 0x6f, 0x6f, 0x6d, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x52, 0x6f,
+	0x6f, 0x6d, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x48, 0x00, 0x52, 0x08, 0x6a, 0x6f, 0x69,
+	0x6e, 0x52, 0x6f, 0x6f, 0x6d, 0x12, 0x2d, 0x0a, 0x0a, 0x6c, 0x65, 0x61, 0x76, 0x65, 0x5f, 0x72,
+	0x6f, 0x6f, 0x6d, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x52, 0x6f, 0x6f, 0x6d,
+	0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x48, 0x00, 0x52, 0x09, 0x6c, 0x65, 0x61, 0x76, 0x65,
+	0x52, 0x6f, 0x6f, 0x6d, 0x12, 0x28, 0x0a, 0x06, 0x72, 0x65, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x06,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x0e, 0x2e, 0x52, 0x65, 0x6e, 0x61, 0x6d, 0x65, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x48, 0x00, 0x52, 0x06, 0x72, 0x65, 0x6e, 0x61, 0x6d, 0x65, 0x12, 0x24,
+	0x0a, 0x07, 0x72, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x18, 0x07, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x0a, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x49, 0x64, 0x52, 0x07, 0x72, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x08, 0x20, 0x01,
+	0x28, 0x03, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12, 0x18, 0x0a, 0x07, 0x67, 0x6f, 0x6f, 0x64,
+	0x62, 0x79, 0x65, 0x18, 0x09, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x67, 0x6f, 0x6f, 0x64, 0x62,
+	0x79, 0x65, 0x42, 0x04, 0x0a, 0x02, 0x6f, 0x70, 0x22, 0x66, 0x0a, 0x08, 0x41, 0x6e, 0x73, 0x77,
+	0x65, 0x72, 0x65, 0x64, 0x12, 0x1a, 0x0a, 0x02, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x0a, 0x2e, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x49, 0x64, 0x52, 0x02, 0x69, 0x64,
+	0x12, 0x12, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04,
+	0x74, 0x69, 0x6d, 0x65, 0x12, 0x2a, 0x0a, 0x09, 0x62, 0x72, 0x6f, 0x61, 0x64, 0x63, 0x61, 0x73,
+	0x74, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x47, 0x65, 0x6e, 0x65, 0x72, 0x69,
+	0x63, 0x54, 0x65, 0x78, 0x74, 0x52, 0x09, 0x62, 0x72, 0x6f, 0x61, 0x64, 0x63, 0x61, 0x73, 0x74,
+	0x22, 0xc6, 0x02, 0x0a, 0x05, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x29, 0x0a, 0x07, 0x63, 0x6c,
+	0x69, 0x65, 0x6e, 0x74, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0f, 0x2e, 0x43, 0x6f,
+	0x6e, 0x6e, 0x65, 0x63, 0x74, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x52, 0x07, 0x63, 0x6c,
+	0x69, 0x65, 0x6e, 0x74, 0x73, 0x12, 0x1b, 0x0a, 0x05, 0x72, 0x6f, 0x6f, 0x6d, 0x73, 0x18, 0x02,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x05, 0x2e, 0x52, 0x6f, 0x6f, 0x6d, 0x52, 0x05, 0x72, 0x6f, 0x6f,
+	0x6d, 0x73, 0x12, 0x10, 0x0a, 0x03, 0x73, 0x65, 0x71, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x03, 0x73, 0x65, 0x71, 0x12, 0x26, 0x0a, 0x07, 0x68, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x18,
+	0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x0c, 0x2e, 0x47, 0x65, 0x6e, 0x65, 0x72, 0x69, 0x63, 0x54,
+	0x65, 0x78, 0x74, 0x52, 0x07, 0x68, 0x69, 0x73, 0x74, 0x6f, 0x72, 0x79, 0x12, 0x17, 0x0a, 0x07,
+	0x6c, 0x61, 0x73, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x06, 0x6c,
+	0x61, 0x73, 0x74, 0x49, 0x64, 0x12, 0x18, 0x0a, 0x07, 0x6c, 0x61, 0x6d, 0x70, 0x6f, 0x72, 0x74,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x04, 0x52, 0x07, 0x6c, 0x61, 0x6d, 0x70, 0x6f, 0x72, 0x74, 0x12,
+	0x25, 0x0a, 0x08, 0x61, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x65, 0x64, 0x18, 0x07, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x09, 0x2e, 0x41, 0x6e, 0x73, 0x77, 0x65, 0x72, 0x65, 0x64, 0x52, 0x08, 0x61, 0x6e,
+	0x73, 0x77, 0x65, 0x72, 0x65, 0x64, 0x12, 0x27, 0x0a, 0x05, 0x6c, 0x6f, 0x62, 0x62, 0x79, 0x18,
+	0x08, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x11, 0x2e, 0x53, 0x74, 0x61, 0x74, 0x65, 0x2e, 0x4c, 0x6f,
+	0x62, 0x62, 0x79, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x05, 0x6c, 0x6f, 0x62, 0x62, 0x79, 0x1a,
+	0x38, 0x0a, 0x0a, 0x4c, 0x6f, 0x62, 0x62, 0x79, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a,
+	0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12,
+	0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x42, 0x0c, 0x5a, 0x0a, 0x2e, 0x2e, 0x2f,
+	0x70, 0x72, 0x6f, 0x67, 0x72, 0x61, 0x6d, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_route_record_proto_rawDescOnce sync.Once
+	file_route_record_proto_rawDescData = file_route_record_proto_rawDesc
+)
+
+func file_route_record_proto_rawDescGZIP() []byte {
+	file_route_record_proto_rawDescOnce.Do(func() {
+		file_route_record_proto_rawDescData = protoimpl.X.CompressGZIP(file_route_record_proto_rawDescData)
+	})
+	return file_route_record_proto_rawDescData
+}
+
+var file_route_record_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_route_record_proto_goTypes = []interface{}{
+	(*Record)(nil),         // 0: Record
+	(*Answered)(nil),       // 1: Answered
+	(*State)(nil),          // 2: State
+	nil,                    // 3: State.LobbyEntry
+	(*ConnectRequest)(nil), // 4: ConnectRequest
+	(*Client)(nil),         // 5: Client
+	(*GenericText)(nil),    // 6: GenericText
+	(*RoomRequest)(nil),    // 7: RoomRequest
+	(*RenameRequest)(nil),  // 8: RenameRequest
+	(*RequestId)(nil),      // 9: RequestId
+	(*Room)(nil),           // 10: Room
+}
+var file_route_record_proto_depIdxs = []int32{
+	4,  // 0: Record.connect:type_name -> ConnectRequest
+	5,  // 1: Record.disconnect:type_name -> Client
+	6,  // 2: Record.broadcast:type_name -> GenericText
+	7,  // 3: Record.join_room:type_name -> RoomRequest
+	7,  // 4: Record.leave_room:type_name -> RoomRequest
+	8,  // 5: Record.rename:type_name -> RenameRequest
+	9,  // 6: Record.request:type_name -> RequestId
+	9,  // 7: Answered.id:type_name -> RequestId
+	6,  // 8: Answered.broadcast:type_name -> GenericText
+	4,  // 9: State.clients:type_name -> ConnectRequest
+	10, // 10: State.rooms:type_name -> Room
+	6,  // 11: State.history:type_name -> GenericText
+	1,  // 12: State.answered:type_name -> Answered
+	3,  // 13: State.lobby:type_name -> State.LobbyEntry
+	14, // [14:14] is the sub-list for method output_type
+	14, // [14:14] is the sub-list for method input_type
+	14, // [14:14] is the sub-list for extension type_name
+	14, // [14:14] is the sub-list for extension extendee
+	0,  // [0:14] is the sub-list for field type_name
+}
+
+func init() { file_route_record_proto_init() }
+func file_route_record_proto_init() {
+	if File_route_record_proto != nil {
+		return
+	}
+	file_route_route_proto_init()
+	if !protoimpl.UnsafeEnabled {
+		file_route_record_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Record); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_route_record_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*Answered); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_route_record_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*State); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	file_route_record_proto_msgTypes[0].OneofWrappers = []interface{}{
+		(*Record_Connect)(nil),
+		(*Record_Disconnect)(nil),
+		(*Record_Broadcast)(nil),
+		(*Record_JoinRoom)(nil),
+		(*Record_LeaveRoom)(nil),
+		(*Record_Rename)(nil),
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_route_record_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   0,
+		},
+		GoTypes:           file_route_record_proto_goTypes,
+		DependencyIndexes: file_route_record_proto_depIdxs,
+		MessageInfos:      file_route_record_proto_msgTypes,
+	}.Build()
+	File_route_record_proto = out.File
+	file_route_record_proto_rawDesc = nil
+	file_route_record_proto_goTypes = nil
+	file_route_record_proto_depIdxs = nil
+}
@@ -1,4 +1,8 @@
 // Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             v3.17.3
+// source: route/route.proto
 
 package program
 
@@ -21,6 +25,17 @@ type RouteClient interface {
 	Connect(ctx context.Context, in *ConnectRequest, opts ...grpc.CallOption) (*Acknowledgement, error)
 	SayHello(ctx context.Context, in *RequestText, opts ...grpc.CallOption) (*ReplyText, error)
 	BroadcastMessage(ctx context.Context, in *RequestText, opts ...grpc.CallOption) (*GenericText, error)
+	Subscribe(ctx context.Context, in *Client, opts ...grpc.CallOption) (Route_SubscribeClient, error)
+	Chat(ctx context.Context, opts ...grpc.CallOption) (Route_ChatClient, error)
+	Disconnect(ctx context.Context, in *Client, opts ...grpc.CallOption) (*Acknowledgement, error)
+	Heartbeat(ctx context.Context, in *Client, opts ...grpc.CallOption) (*Acknowledgement, error)
+	Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchReply, error)
+	History(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*HistoryPage, error)
+	JoinRoom(ctx context.Context, in *RoomRequest, opts ...grpc.CallOption) (*Acknowledgement, error)
+	LeaveRoom(ctx context.Context, in *RoomRequest, opts ...grpc.CallOption) (*Acknowledgement, error)
+	ListRooms(ctx context.Context, in *Client, opts ...grpc.CallOption) (*RoomList, error)
+	SendDirect(ctx context.Context, in *RequestText, opts ...grpc.CallOption) (*GenericText, error)
+	Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*Acknowledgement, error)
 }
 
 type routeClient struct {
@@ -58,6 +73,150 @@ func (c *routeClient) BroadcastMessage(ctx context.Context, in *RequestText, opt
 	return out, nil
 }
 
+func (c *routeClient) Subscribe(ctx context.Context, in *Client, opts ...grpc.CallOption) (Route_SubscribeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Route_ServiceDesc.Streams[0], "/Route/Subscribe", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &routeSubscribeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Route_SubscribeClient interface {
+	Recv() (*GenericText, error)
+	grpc.ClientStream
+}
+
+type routeSubscribeClient struct {
+	grpc.ClientStream
+}
+
+func (x *routeSubscribeClient) Recv() (*GenericText, error) {
+	m := new(GenericText)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *routeClient) Chat(ctx context.Context, opts ...grpc.CallOption) (Route_ChatClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Route_ServiceDesc.Streams[1], "/Route/Chat", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &routeChatClient{stream}
+	return x, nil
+}
+
+type Route_ChatClient interface {
+	Send(*ChatMessage) error
+	Recv() (*ChatMessage, error)
+	grpc.ClientStream
+}
+
+type routeChatClient struct {
+	grpc.ClientStream
+}
+
+func (x *routeChatClient) Send(m *ChatMessage) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *routeChatClient) Recv() (*ChatMessage, error) {
+	m := new(ChatMessage)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *routeClient) Disconnect(ctx context.Context, in *Client, opts ...grpc.CallOption) (*Acknowledgement, error) {
+	out := new(Acknowledgement)
+	err := c.cc.Invoke(ctx, "/Route/Disconnect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeClient) Heartbeat(ctx context.Context, in *Client, opts ...grpc.CallOption) (*Acknowledgement, error) {
+	out := new(Acknowledgement)
+	err := c.cc.Invoke(ctx, "/Route/Heartbeat", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeClient) Fetch(ctx context.Context, in *FetchRequest, opts ...grpc.CallOption) (*FetchReply, error) {
+	out := new(FetchReply)
+	err := c.cc.Invoke(ctx, "/Route/Fetch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeClient) History(ctx context.Context, in *HistoryRequest, opts ...grpc.CallOption) (*HistoryPage, error) {
+	out := new(HistoryPage)
+	err := c.cc.Invoke(ctx, "/Route/History", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeClient) JoinRoom(ctx context.Context, in *RoomRequest, opts ...grpc.CallOption) (*Acknowledgement, error) {
+	out := new(Acknowledgement)
+	err := c.cc.Invoke(ctx, "/Route/JoinRoom", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeClient) LeaveRoom(ctx context.Context, in *RoomRequest, opts ...grpc.CallOption) (*Acknowledgement, error) {
+	out := new(Acknowledgement)
+	err := c.cc.Invoke(ctx, "/Route/LeaveRoom", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeClient) ListRooms(ctx context.Context, in *Client, opts ...grpc.CallOption) (*RoomList, error) {
+	out := new(RoomList)
+	err := c.cc.Invoke(ctx, "/Route/ListRooms", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeClient) SendDirect(ctx context.Context, in *RequestText, opts ...grpc.CallOption) (*GenericText, error) {
+	out := new(GenericText)
+	err := c.cc.Invoke(ctx, "/Route/SendDirect", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *routeClient) Rename(ctx context.Context, in *RenameRequest, opts ...grpc.CallOption) (*Acknowledgement, error) {
+	out := new(Acknowledgement)
+	err := c.cc.Invoke(ctx, "/Route/Rename", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // RouteServer is the server API for Route service.
 // All implementations must embed UnimplementedRouteServer
 // for forward compatibility
@@ -65,6 +224,17 @@ type RouteServer interface {
 	Connect(context.Context, *ConnectRequest) (*Acknowledgement, error)
 	SayHello(context.Context, *RequestText) (*ReplyText, error)
 	BroadcastMessage(context.Context, *RequestText) (*GenericText, error)
+	Subscribe(*Client, Route_SubscribeServer) error
+	Chat(Route_ChatServer) error
+	Disconnect(context.Context, *Client) (*Acknowledgement, error)
+	Heartbeat(context.Context, *Client) (*Acknowledgement, error)
+	Fetch(context.Context, *FetchRequest) (*FetchReply, error)
+	History(context.Context, *HistoryRequest) (*HistoryPage, error)
+	JoinRoom(context.Context, *RoomRequest) (*Acknowledgement, error)
+	LeaveRoom(context.Context, *RoomRequest) (*Acknowledgement, error)
+	ListRooms(context.Context, *Client) (*RoomList, error)
+	SendDirect(context.Context, *RequestText) (*GenericText, error)
+	Rename(context.Context, *RenameRequest) (*Acknowledgement, error)
 	mustEmbedUnimplementedRouteServer()
 }
 
@@ -81,6 +251,39 @@ func (UnimplementedRouteServer) SayHello(context.Context, *RequestText) (*ReplyT
 func (UnimplementedRouteServer) BroadcastMessage(context.Context, *RequestText) (*GenericText, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method BroadcastMessage not implemented")
 }
+func (UnimplementedRouteServer) Subscribe(*Client, Route_SubscribeServer) error {
+	return status.Errorf(codes.Unimplemented, "method Subscribe not implemented")
+}
+func (UnimplementedRouteServer) Chat(Route_ChatServer) error {
+	return status.Errorf(codes.Unimplemented, "method Chat not implemented")
+}
+func (UnimplementedRouteServer) Disconnect(context.Context, *Client) (*Acknowledgement, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Disconnect not implemented")
+}
+func (UnimplementedRouteServer) Heartbeat(context.Context, *Client) (*Acknowledgement, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Heartbeat not implemented")
+}
+func (UnimplementedRouteServer) Fetch(context.Context, *FetchRequest) (*FetchReply, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Fetch not implemented")
+}
+func (UnimplementedRouteServer) History(context.Context, *HistoryRequest) (*HistoryPage, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method History not implemented")
+}
+func (UnimplementedRouteServer) JoinRoom(context.Context, *RoomRequest) (*Acknowledgement, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method JoinRoom not implemented")
+}
+func (UnimplementedRouteServer) LeaveRoom(context.Context, *RoomRequest) (*Acknowledgement, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method LeaveRoom not implemented")
+}
+func (UnimplementedRouteServer) ListRooms(context.Context, *Client) (*RoomList, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListRooms not implemented")
+}
+func (UnimplementedRouteServer) SendDirect(context.Context, *RequestText) (*GenericText, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method SendDirect not implemented")
+}
+func (UnimplementedRouteServer) Rename(context.Context, *RenameRequest) (*Acknowledgement, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Rename not implemented")
+}
 func (UnimplementedRouteServer) mustEmbedUnimplementedRouteServer() {}
 
 // UnsafeRouteServer may be embedded to opt out of forward compatibility for this service.
@@ -148,6 +351,215 @@ func _Route_BroadcastMessage_Handler(srv interface{}, ctx context.Context, dec f
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Route_Subscribe_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(Client)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(RouteServer).Subscribe(m, &routeSubscribeServer{stream})
+}
+
+type Route_SubscribeServer interface {
+	Send(*GenericText) error
+	grpc.ServerStream
+}
+
+type routeSubscribeServer struct {
+	grpc.ServerStream
+}
+
+func (x *routeSubscribeServer) Send(m *GenericText) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _Route_Chat_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(RouteServer).Chat(&routeChatServer{stream})
+}
+
+type Route_ChatServer interface {
+	Send(*ChatMessage) error
+	Recv() (*ChatMessage, error)
+	grpc.ServerStream
+}
+
+type routeChatServer struct {
+	grpc.ServerStream
+}
+
+func (x *routeChatServer) Send(m *ChatMessage) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *routeChatServer) Recv() (*ChatMessage, error) {
+	m := new(ChatMessage)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _Route_Disconnect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Client)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).Disconnect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/Disconnect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).Disconnect(ctx, req.(*Client))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Route_Heartbeat_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Client)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).Heartbeat(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/Heartbeat",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).Heartbeat(ctx, req.(*Client))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Route_Fetch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FetchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).Fetch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/Fetch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).Fetch(ctx, req.(*FetchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Route_History_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(HistoryRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).History(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/History",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).History(ctx, req.(*HistoryRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Route_JoinRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoomRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).JoinRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/JoinRoom",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).JoinRoom(ctx, req.(*RoomRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Route_LeaveRoom_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RoomRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).LeaveRoom(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/LeaveRoom",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).LeaveRoom(ctx, req.(*RoomRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Route_ListRooms_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(Client)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).ListRooms(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/ListRooms",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).ListRooms(ctx, req.(*Client))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Route_SendDirect_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RequestText)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).SendDirect(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/SendDirect",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).SendDirect(ctx, req.(*RequestText))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Route_Rename_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RenameRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RouteServer).Rename(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/Route/Rename",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RouteServer).Rename(ctx, req.(*RenameRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Route_ServiceDesc is the grpc.ServiceDesc for Route service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -167,7 +579,55 @@ var Route_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "BroadcastMessage",
 			Handler:    _Route_BroadcastMessage_Handler,
 		},
+		{
+			MethodName: "Disconnect",
+			Handler:    _Route_Disconnect_Handler,
+		},
+		{
+			MethodName: "Heartbeat",
+			Handler:    _Route_Heartbeat_Handler,
+		},
+		{
+			MethodName: "Fetch",
+			Handler:    _Route_Fetch_Handler,
+		},
+		{
+			MethodName: "History",
+			Handler:    _Route_History_Handler,
+		},
+		{
+			MethodName: "JoinRoom",
+			Handler:    _Route_JoinRoom_Handler,
+		},
+		{
+			MethodName: "LeaveRoom",
+			Handler:    _Route_LeaveRoom_Handler,
+		},
+		{
+			MethodName: "ListRooms",
+			Handler:    _Route_ListRooms_Handler,
+		},
+		{
+			MethodName: "SendDirect",
+			Handler:    _Route_SendDirect_Handler,
+		},
+		{
+			MethodName: "Rename",
+			Handler:    _Route_Rename_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Subscribe",
+			Handler:       _Route_Subscribe_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "Chat",
+			Handler:       _Route_Chat_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "route/route.proto",
 }
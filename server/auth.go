@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	pb "program/route"
+	"strings"
+	"sync"
+)
+
+// The metadata key clients put their session token under
+const tokenKey = "route-token"
+
+// Calls that don't need a session, because they are how a client gets one
+var public = map[string]bool{
+	"/Route/Connect": true,
+}
+
+// The Raft and Peer services are for the other servers of the cluster, which have no session
+const (
+	raftService = "/Raft/"
+	peerService = "/Peer/"
+)
+
+func internal(method string) bool {
+	return strings.HasPrefix(method, raftService) || strings.HasPrefix(method, peerService)
+}
+
+// sessions maps the tokens handed out by Connect to the clients they belong to
+type sessions struct {
+	mu      sync.Mutex
+	byToken map[string]int64
+	byID    map[int64]string
+}
+
+func newSessions() *sessions {
+	return &sessions{byToken: make(map[string]int64), byID: make(map[int64]string)}
+}
+
+// grant ties token to the client, replacing any token it had before
+func (s *sessions) grant(token string, id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byToken, s.byID[id])
+	s.byToken[token] = id
+	s.byID[id] = token
+}
+
+func (s *sessions) revoke(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.byToken, s.byID[id])
+	delete(s.byID, id)
+}
+
+// token returns the client's current token, empty when it has none
+func (s *sessions) token(id int64) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.byID[id]
+}
+
+// resolve returns the client the token belongs to
+func (s *sessions) resolve(token string) (int64, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id, ok := s.byToken[token]
+	return id, ok
+}
+
+// newToken returns a fresh random session token
+func newToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// caller resolves the session token in the call's metadata to a client id.
+// A caller with a client certificate must also be the client the certificate names.
+func (s *server) caller(ctx context.Context) (int64, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	tokens := md.Get(tokenKey)
+	if len(tokens) != 1 {
+		return 0, routeError(codes.Unauthenticated, pb.ErrorReason_INVALID_TOKEN, "missing session token, call Connect first")
+	}
+	id, ok := s.sessions.resolve(tokens[0])
+	if !ok {
+		return 0, routeError(codes.Unauthenticated, pb.ErrorReason_INVALID_TOKEN, "unknown or expired session token")
+	}
+	if cn := certName(ctx); cn != "" && !strings.EqualFold(cn, s.name(id)) {
+		return 0, errCertMismatch(cn)
+	}
+	return id, nil
+}
+
+// certName returns the common name of the caller's verified client certificate,
+// empty when it didn't present one. The name is the client's chat identity.
+func certName(ctx context.Context) string {
+	if cert := verifiedCert(ctx); cert != nil {
+		return cert.Subject.CommonName
+	}
+	return ""
+}
+
+// verifiedCert returns the certificate the caller presented, nil unless it has one
+// signed by -tls-ca
+func verifiedCert(ctx context.Context) *x509.Certificate {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return nil
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return info.State.VerifiedChains[0][0]
+}
+
+// checkPeer lets calls from the other servers of the cluster through. They come with
+// a server certificate signed by -tls-ca for the host of one of the members; client
+// certificates are signed by the same CA but can't serve, so they don't pass.
+func (s *server) checkPeer(ctx context.Context, req interface{}) error {
+	cert := verifiedCert(ctx)
+	serves := false
+	if cert != nil {
+		for _, usage := range cert.ExtKeyUsage {
+			serves = serves || usage == x509.ExtKeyUsageServerAuth
+		}
+	}
+	if !serves {
+		return routeError(codes.PermissionDenied, pb.ErrorReason_NOT_A_PEER, "only the servers of the cluster may call this")
+	}
+
+	members := s.members()
+	//A node that is yet to be added hears from whichever server leads
+	if len(members) == 0 {
+		return nil
+	}
+	//A server may ask to be added itself
+	if change, ok := req.(*pb.MembershipChange); ok && !change.Remove {
+		members = append(members, change.Node)
+	}
+	for _, member := range members {
+		host, _, err := net.SplitHostPort(member)
+		if err == nil && cert.VerifyHostname(host) == nil {
+			return nil
+		}
+	}
+	return routeError(codes.PermissionDenied, pb.ErrorReason_NOT_A_PEER, "the certificate of "+cert.Subject.CommonName+" is for none of the cluster's servers")
+}
+
+// members returns the addresses of the servers of the cluster
+func (s *server) members() []string {
+	if s.raft != nil {
+		if members := s.raft.Membership(); len(members) > 0 {
+			return members
+		}
+	}
+	members := cfg.peers()
+	if cfg.RaftJoin != "" {
+		members = append(members, cfg.RaftJoin)
+	}
+	group, _ := cfg.group()
+	for _, p := range group {
+		members = append(members, p.Addr)
+	}
+	return members
+}
+
+// address returns where the call came from
+func address(ctx context.Context) string {
+	if p, ok := peer.FromContext(ctx); ok {
+		return p.Addr.String()
+	}
+	return "unknown address"
+}
+
+func errCertMismatch(cn string) error {
+	return routeError(codes.Unauthenticated, pb.ErrorReason_IDENTITY_MISMATCH, "the client certificate belongs to "+cn)
+}
+
+func errUserMismatch(user string) error {
+	return routeError(codes.Unauthenticated, pb.ErrorReason_IDENTITY_MISMATCH, "logged in as "+user+", the name has to match")
+}
+
+// claimed returns the client a request says it comes from
+func claimed(req interface{}) *pb.Client {
+	switch req := req.(type) {
+	case *pb.Client:
+		return req
+	case *pb.ChatMessage:
+		return req.GetSend().GetClient()
+	case interface{ GetClient() *pb.Client }:
+		return req.GetClient()
+	}
+	return nil
+}
+
+// checkClaim refuses requests that name a different client than the session's
+func checkClaim(id int64, req interface{}) error {
+	if claimed(req).GetId() != id {
+		return routeError(codes.Unauthenticated, pb.ErrorReason_IDENTITY_MISMATCH, "the request is not from the client the session belongs to")
+	}
+	return nil
+}
+
+// authUnary makes every unary call but Connect prove who it comes from
+func (s *server) authUnary(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if internal(info.FullMethod) {
+		if err := s.checkPeer(ctx, req); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+	if err := s.redirect(); err != nil {
+		return nil, err
+	}
+	if public[info.FullMethod] {
+		return handler(ctx, req)
+	}
+	id, err := s.caller(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkClaim(id, req); err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+// authStream does the same for streams, checking every message the client sends
+func (s *server) authStream(srv interface{}, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if err := s.redirect(); err != nil {
+		return err
+	}
+	id, err := s.caller(stream.Context())
+	if err != nil {
+		return err
+	}
+	return handler(srv, &authStream{ServerStream: stream, id: id})
+}
+
+type authStream struct {
+	grpc.ServerStream
+	id int64
+}
+
+func (a *authStream) RecvMsg(m interface{}) error {
+	if err := a.ServerStream.RecvMsg(m); err != nil {
+		return err
+	}
+	return checkClaim(a.id, m)
+}
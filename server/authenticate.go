@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"golang.org/x/crypto/bcrypt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// An Authenticator decides whether a client may connect
+type Authenticator interface {
+	// Authenticate returns an error unless secret proves the caller may connect as user
+	Authenticate(user, secret string) error
+	// Identifies reports whether Authenticate proves who the user is, in which case
+	// the user name is the client's chat identity
+	Identifies() bool
+}
+
+var errBadCredentials = errors.New("wrong user name or password")
+
+// passwordFile checks passwords against bcrypt hashes, one "user:hash" line per
+// user, as written by htpasswd -nbB. Blank lines and lines starting with # are skipped.
+type passwordFile struct {
+	hashes map[string][]byte
+	//Checked when the user doesn't exist, so unknown and known users take as long
+	decoy []byte
+}
+
+func loadPasswordFile(path string) (*passwordFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoy, err := bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	users := &passwordFile{hashes: make(map[string][]byte), decoy: decoy}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		i := strings.Index(text, ":")
+		if i < 1 {
+			return nil, errors.New(path + ": line " + strconv.Itoa(line) + " is not user:hash")
+		}
+		users.hashes[text[:i]] = []byte(text[i+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (p *passwordFile) Authenticate(user, secret string) error {
+	hash, ok := p.hashes[user]
+	if !ok {
+		bcrypt.CompareHashAndPassword(p.decoy, []byte(secret))
+		return errBadCredentials
+	}
+	if bcrypt.CompareHashAndPassword(hash, []byte(secret)) != nil {
+		return errBadCredentials
+	}
+	return nil
+}
+
+func (p *passwordFile) Identifies() bool {
+	return true
+}
+
+// staticToken lets in anyone who knows the pre-shared key, whatever user name they give
+type staticToken struct {
+	key []byte
+}
+
+func loadStaticToken(path string) (*staticToken, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return nil, errors.New(path + " is empty")
+	}
+	return &staticToken{key: []byte(key)}, nil
+}
+
+func (t *staticToken) Authenticate(user, secret string) error {
+	if subtle.ConstantTimeCompare(t.key, []byte(secret)) != 1 {
+		return errors.New("wrong pre-shared key")
+	}
+	return nil
+}
+
+func (t *staticToken) Identifies() bool {
+	//Everyone shares the key, the user name is whatever they say it is
+	return false
+}
+
+// newAuthenticator picks the authenticator the flags ask for, nil lets everyone in
+func newAuthenticator(usersFile, keyFile string) (Authenticator, error) {
+	switch {
+	case usersFile != "" && keyFile != "":
+		return nil, errors.New("use either a users file or a pre-shared key, not both")
+	case usersFile != "":
+		return loadPasswordFile(usersFile)
+	case keyFile != "":
+		return loadStaticToken(keyFile)
+	}
+	return nil, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"golang.org/x/crypto/bcrypt"
+	"os"
+	"path/filepath"
+	pb "program/route"
+	"testing"
+	"time"
+)
+
+// serverWithUsers makes a server that logs users in from a users file holding alice and bob
+func serverWithUsers(t *testing.T) *server {
+	t.Helper()
+	var lines []byte
+	for _, user := range []string{"alice", "bob"} {
+		hash, err := bcrypt.GenerateFromPassword([]byte(user+"-secret"), bcrypt.MinCost)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, user+":"+string(hash)+"\n"...)
+	}
+	path := filepath.Join(t.TempDir(), "users")
+	if err := os.WriteFile(path, lines, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := newAuthenticator(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(time.Now)
+	s.auth = auth
+	return s
+}
+
+func TestUserIsTheChatName(t *testing.T) {
+	s := serverWithUsers(t)
+	ack, err := s.Connect(context.Background(), &pb.ConnectRequest{User: "alice", Password: "alice-secret"})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if ack.Client.Name != "alice" {
+		t.Fatalf("connected as %q, want the user name", ack.Client.Name)
+	}
+
+	_, err = s.Rename(context.Background(), &pb.RenameRequest{Client: ack.Client, Name: "bob"})
+	if reasonOf(err) != pb.ErrorReason_INVALID_NAME.String() {
+		t.Fatalf("rename of a logged in user: %v", err)
+	}
+}
+
+func TestUserCannotChatAsSomeoneElse(t *testing.T) {
+	s := serverWithUsers(t)
+	_, err := s.Connect(context.Background(), &pb.ConnectRequest{User: "alice", Password: "alice-secret", Name: "bob"})
+	if reasonOf(err) != pb.ErrorReason_IDENTITY_MISMATCH.String() {
+		t.Fatalf("alice connecting as bob: %v", err)
+	}
+	if _, taken := s.clients.owner("bob"); taken {
+		t.Fatal("alice got registered as bob")
+	}
+}
+
+func TestSharedKeyLeavesTheNameFree(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(path, []byte("shared"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := newAuthenticator("", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newServer(time.Now)
+	s.auth = auth
+	ack, err := s.Connect(context.Background(), &pb.ConnectRequest{User: "alice", Password: "shared", Name: "carol"})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if ack.Client.Name != "carol" {
+		t.Fatalf("connected as %q, want the name asked for", ack.Client.Name)
+	}
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"net"
+	"os"
+	"program/wal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Environment variables named after a setting override the config file, and
+// flags override both, e.g. ROUTE_LEASE_TTL=30s or -lease-ttl 30s
+const envPrefix = "ROUTE_"
+
+// config holds every setting of the server. The config file is a JSON object
+// keyed by flag name, e.g. {"listen": ":5000", "lease-ttl": "10s"}.
+type config struct {
+	Listen string
+
+	//Membership
+	LeaseTTL     time.Duration
+	ReapInterval time.Duration
+
+	//Limits
+	MaxBody          int
+	MaxFetch         int
+	RetransmitSize   int
+	SubscriberBuffer int
+	//How long a message sent with a request id is remembered, so a retry isn't broadcast again
+	DedupTTL time.Duration
+
+	//Persistence
+	DataDir     string
+	SegmentSize int64
+
+	//TLS
+	TLSCert           string
+	TLSKey            string
+	TLSCA             string
+	RequireClientCert bool
+
+	//Authentication
+	UsersFile   string
+	AuthKeyFile string
+
+	//Logging, empty writes to stderr
+	LogFile string
+
+	//How long in-flight calls get to finish on shutdown before they are cut off
+	ShutdownTimeout time.Duration
+
+	//Replication, off unless raft-peers or raft-join is set
+	Advertise           string
+	RaftPeers           string
+	RaftJoin            string
+	RaftElectionTimeout time.Duration
+	RaftHeartbeat       time.Duration
+	RaftSnapshotEvery   int
+	RaftCommitTimeout   time.Duration
+
+	//Leader election without replication, off unless election is set
+	Election        string
+	NodeID          int64
+	Peers           string
+	ElectionTimeout time.Duration
+}
+
+// cfg is the configuration the server runs with
+var cfg = defaultConfig()
+
+func defaultConfig() config {
+	return config{
+		Listen:           ":5000",
+		LeaseTTL:         10 * time.Second,
+		ReapInterval:     2 * time.Second,
+		MaxBody:          4096,
+		MaxFetch:         256,
+		RetransmitSize:   1024,
+		SubscriberBuffer: 64,
+		DedupTTL:         10 * time.Minute,
+		SegmentSize:      4 << 20,
+		ShutdownTimeout:  10 * time.Second,
+
+		RaftElectionTimeout: 300 * time.Millisecond,
+		RaftHeartbeat:       50 * time.Millisecond,
+		RaftSnapshotEvery:   1024,
+		RaftCommitTimeout:   5 * time.Second,
+
+		ElectionTimeout: time.Second,
+	}
+}
+
+// flags binds a flag to every setting of c
+func (c *config) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.StringVar(&c.Listen, "listen", c.Listen, "Address to serve on")
+	fs.DurationVar(&c.LeaseTTL, "lease-ttl", c.LeaseTTL, "How long a client stays connected without a heartbeat")
+	fs.DurationVar(&c.ReapInterval, "reap-interval", c.ReapInterval, "How often expired leases are evicted")
+	fs.IntVar(&c.MaxBody, "max-body", c.MaxBody, "The longest message body the server accepts, in bytes")
+	fs.IntVar(&c.MaxFetch, "max-fetch", c.MaxFetch, "The most messages a single Fetch returns")
+	fs.IntVar(&c.RetransmitSize, "retransmit-buffer", c.RetransmitSize, "How many recent broadcasts are kept for Fetch")
+	fs.IntVar(&c.SubscriberBuffer, "subscriber-buffer", c.SubscriberBuffer, "How many broadcasts may queue up for one client before new ones are dropped")
+	fs.DurationVar(&c.DedupTTL, "dedup-ttl", c.DedupTTL, "How long a message sent with a request id is remembered, so that a retry of it isn't broadcast again")
+	fs.StringVar(&c.DataDir, "data-dir", c.DataDir, "Directory for the write-ahead log, empty keeps everything in memory")
+	fs.Int64Var(&c.SegmentSize, "segment-size", c.SegmentSize, "Size in bytes at which the write-ahead log starts a new segment")
+	fs.StringVar(&c.TLSCert, "tls-cert", c.TLSCert, "Server certificate, empty serves plaintext")
+	fs.StringVar(&c.TLSKey, "tls-key", c.TLSKey, "Key of the server certificate")
+	fs.StringVar(&c.TLSCA, "tls-ca", c.TLSCA, "CA that signs client certificates, empty accepts no client certificates")
+	fs.BoolVar(&c.RequireClientCert, "require-client-cert", c.RequireClientCert, "Refuse clients without a certificate signed by -tls-ca")
+	fs.StringVar(&c.UsersFile, "users-file", c.UsersFile, "File of user:bcrypt-hash lines, as made by htpasswd -nbB, that clients must log in with. Clients chat under their user name")
+	fs.StringVar(&c.AuthKeyFile, "auth-key-file", c.AuthKeyFile, "File holding a pre-shared key that clients must connect with")
+	fs.StringVar(&c.LogFile, "log-file", c.LogFile, "File to append the log to, empty logs to stderr")
+	fs.DurationVar(&c.ShutdownTimeout, "shutdown-timeout", c.ShutdownTimeout, "How long in-flight calls get to finish on shutdown before they are cut off")
+	fs.StringVar(&c.Advertise, "advertise", c.Advertise, "Address the other servers of the cluster and redirected clients reach this one at, empty takes it from -listen")
+	fs.StringVar(&c.RaftPeers, "raft-peers", c.RaftPeers, "Comma separated addresses of every server of the cluster, this one included, to replicate the state with Raft")
+	fs.StringVar(&c.RaftJoin, "raft-join", c.RaftJoin, "Address of a server of a running cluster to join through")
+	fs.DurationVar(&c.RaftElectionTimeout, "raft-election-timeout", c.RaftElectionTimeout, "How long a follower waits to hear from the leader before standing for election, randomized up to twice as long")
+	fs.DurationVar(&c.RaftHeartbeat, "raft-heartbeat", c.RaftHeartbeat, "How often the leader contacts idle followers")
+	fs.IntVar(&c.RaftSnapshotEvery, "raft-snapshot-every", c.RaftSnapshotEvery, "Compact the Raft log after this many entries, 0 never does")
+	fs.DurationVar(&c.RaftCommitTimeout, "raft-commit-timeout", c.RaftCommitTimeout, "How long a change may take to be committed by the cluster before the client is told it failed")
+	fs.StringVar(&c.Election, "election", c.Election, "Elect a leader among -peers to take the clients, without sharing state: bully, ring, or empty for none")
+	fs.Int64Var(&c.NodeID, "node-id", c.NodeID, "This server's id among -peers")
+	fs.StringVar(&c.Peers, "peers", c.Peers, "Comma separated id=address of every server taking part in the election, this one included")
+	fs.DurationVar(&c.ElectionTimeout, "election-timeout", c.ElectionTimeout, "How long to wait for other servers to answer, and how often the leader is checked on")
+	return fs
+}
+
+// loadConfig builds the configuration from the defaults, the config file,
+// the environment and the command line, each overriding the one before.
+// It reports whether the caller only asked to see the result.
+func loadConfig(args []string) (config, bool, error) {
+	c := defaultConfig()
+	fs := c.flags("server")
+	file := fs.String("config", "", "JSON config file, keyed by flag name")
+	printOnly := fs.Bool("print-config", false, "Print the effective configuration as JSON and exit")
+
+	//The first pass only finds the config file, the last pass puts the flags back on top
+	if err := fs.Parse(args); err != nil {
+		return c, false, err
+	}
+	if *file == "" {
+		*file = os.Getenv(envPrefix + "CONFIG")
+	}
+	if *file != "" {
+		if err := loadConfigFile(fs, *file); err != nil {
+			return c, false, err
+		}
+	}
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if f.Name == "config" || f.Name == "print-config" {
+			return
+		}
+		env := envPrefix + strings.ToUpper(strings.Replace(f.Name, "-", "_", -1))
+		if value, ok := os.LookupEnv(env); ok && err == nil {
+			if setErr := fs.Set(f.Name, value); setErr != nil {
+				err = errors.New(env + "=" + value + ": " + setErr.Error())
+			}
+		}
+	})
+	if err != nil {
+		return c, false, err
+	}
+	if err := fs.Parse(args); err != nil {
+		return c, false, err
+	}
+	return c, *printOnly, c.validate()
+}
+
+func loadConfigFile(fs *flag.FlagSet, path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return errors.New(path + ": " + err.Error())
+	}
+	for name, raw := range settings {
+		if name == "config" || name == "print-config" || fs.Lookup(name) == nil {
+			return errors.New(path + ": unknown setting " + strconv.Quote(name))
+		}
+		//Strings are unquoted, numbers and booleans are taken as written
+		value := string(raw)
+		var s string
+		if json.Unmarshal(raw, &s) == nil {
+			value = s
+		}
+		if err := fs.Set(name, value); err != nil {
+			return errors.New(path + ": " + name + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// validate reports every setting that can't work, not just the first
+func (c config) validate() error {
+	var problems []string
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+	_, _, err := net.SplitHostPort(c.Listen)
+	check(err == nil, "listen must be host:port, got "+strconv.Quote(c.Listen))
+	check(c.LeaseTTL > 0, "lease-ttl must be positive")
+	check(c.ReapInterval > 0, "reap-interval must be positive")
+	check(c.MaxBody > 0 && c.MaxBody < wal.MaxRecordSize/2, "max-body must be between 1 and "+strconv.Itoa(wal.MaxRecordSize/2))
+	check(c.MaxFetch > 0, "max-fetch must be at least 1")
+	check(c.RetransmitSize > 0, "retransmit-buffer must be at least 1")
+	check(c.SubscriberBuffer > 0, "subscriber-buffer must be at least 1")
+	check(c.DedupTTL > 0, "dedup-ttl must be positive")
+	check(c.SegmentSize > 0, "segment-size must be positive")
+	check(c.ShutdownTimeout >= 0, "shutdown-timeout can't be negative")
+	check((c.TLSCert == "") == (c.TLSKey == ""), "tls-cert and tls-key go together")
+	check(c.TLSCA == "" || c.TLSCert != "", "tls-ca needs tls-cert")
+	check(!c.RequireClientCert || c.TLSCA != "", "require-client-cert needs tls-ca")
+	check(c.UsersFile == "" || c.AuthKeyFile == "", "use either users-file or auth-key-file, not both")
+	if c.replicated() || c.Election != "" {
+		check(c.TLSCert != "" && c.TLSCA != "", "a cluster needs tls-cert and tls-ca, the servers prove who they are to each other with their certificates")
+	}
+	if c.replicated() {
+		_, _, err := net.SplitHostPort(c.advertise())
+		check(err == nil, "advertise must be host:port, got "+strconv.Quote(c.advertise()))
+		check(c.RaftHeartbeat > 0 && c.RaftHeartbeat < c.RaftElectionTimeout, "raft-heartbeat must be positive and shorter than raft-election-timeout")
+		check(c.RaftSnapshotEvery >= 0, "raft-snapshot-every can't be negative")
+		check(c.RaftCommitTimeout > 0, "raft-commit-timeout must be positive")
+		listed := false
+		for _, peer := range c.peers() {
+			listed = listed || peer == c.advertise()
+		}
+		check(c.RaftPeers == "" || listed, "raft-peers must include this server's own address "+strconv.Quote(c.advertise()))
+	}
+	if c.Election != "" {
+		check(c.Election == "bully" || c.Election == "ring", "election must be bully or ring, got "+strconv.Quote(c.Election))
+		check(!c.replicated(), "use either election or raft, Raft elects its own leader")
+		check(c.ElectionTimeout > 0, "election-timeout must be positive")
+		group, err := c.group()
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+		listed := false
+		for _, p := range group {
+			listed = listed || p.ID == c.NodeID
+		}
+		check(err != nil || listed, "peers must include this server's node-id "+strconv.FormatInt(c.NodeID, 10))
+	}
+	if len(problems) > 0 {
+		return errors.New("invalid configuration:\n  " + strings.Join(problems, "\n  "))
+	}
+	return nil
+}
+
+// replicated reports whether the server is part of a Raft cluster
+func (c config) replicated() bool {
+	return c.RaftPeers != "" || c.RaftJoin != ""
+}
+
+// advertise returns the address the server is known by in its cluster
+func (c config) advertise() string {
+	if c.Advertise != "" {
+		return c.Advertise
+	}
+	host, port, err := net.SplitHostPort(c.Listen)
+	if err != nil || host != "" {
+		return c.Listen
+	}
+	return net.JoinHostPort("localhost", port)
+}
+
+// peers returns the addresses in raft-peers
+func (c config) peers() []string {
+	var peers []string
+	for _, peer := range strings.Split(c.RaftPeers, ",") {
+		if peer = strings.TrimSpace(peer); peer != "" {
+			peers = append(peers, peer)
+		}
+	}
+	return peers
+}
+
+// print writes the configuration as a config file would hold it
+func (c config) print() ([]byte, error) {
+	settings := make(map[string]interface{})
+	c.flags("print").VisitAll(func(f *flag.Flag) {
+		value := f.Value.(flag.Getter).Get()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		settings[f.Name] = value
+	})
+	//encoding/json sorts map keys, so the output is stable
+	return json.MarshalIndent(settings, "", "  ")
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	pb "program/route"
+	"sync"
+	"time"
+)
+
+// errAnswered is what validate says of a retry of a request that was already carried out
+var errAnswered = errors.New("request was already carried out")
+
+// answeredRequests remembers what every message sent with a request id broadcast,
+// so that a retry gets the same broadcast back instead of a second one. It only
+// changes when records are applied, so every server of a cluster agrees on it and
+// it comes back from the write-ahead log. It is safe for concurrent use.
+type answeredRequests struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[requestKey]*pb.Answered
+	//The keys in the order they were added, for expiring the oldest first
+	order []requestKey
+}
+
+type requestKey struct {
+	client int64
+	seq    uint64
+}
+
+func keyOf(id *pb.RequestId) requestKey {
+	return requestKey{client: id.GetClient(), seq: id.GetSeq()}
+}
+
+func newAnsweredRequests(ttl time.Duration) *answeredRequests {
+	return &answeredRequests{ttl: ttl, entries: make(map[requestKey]*pb.Answered)}
+}
+
+// get returns what the request broadcast, if it was carried out
+func (a *answeredRequests) get(id *pb.RequestId) (*pb.GenericText, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	entry, ok := a.entries[keyOf(id)]
+	return entry.GetBroadcast(), ok
+}
+
+// add remembers entry and forgets those more than ttl older than it. Going by the
+// time in the entries rather than the clock keeps every server's table the same.
+func (a *answeredRequests) add(entry *pb.Answered) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := keyOf(entry.Id)
+	a.entries[key] = entry
+	a.order = append(a.order, key)
+
+	oldest := entry.Time - a.ttl.Milliseconds()
+	expired := 0
+	for _, key := range a.order {
+		if a.entries[key].Time >= oldest {
+			break
+		}
+		delete(a.entries, key)
+		expired++
+	}
+	a.order = a.order[expired:]
+}
+
+// forget drops the client's requests, once it has said goodbye and won't retry them
+func (a *answeredRequests) forget(client int64) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	kept := a.order[:0]
+	for _, key := range a.order {
+		if key.client == client {
+			delete(a.entries, key)
+		} else {
+			kept = append(kept, key)
+		}
+	}
+	a.order = kept
+}
+
+// list returns every entry, oldest first
+func (a *answeredRequests) list() []*pb.Answered {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var list []*pb.Answered
+	for _, key := range a.order {
+		if entry, ok := a.entries[key]; ok {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
+
+// replace swaps everything for list, oldest first
+func (a *answeredRequests) replace(list []*pb.Answered) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.entries = make(map[requestKey]*pb.Answered)
+	a.order = a.order[:0]
+	for _, entry := range list {
+		key := keyOf(entry.Id)
+		a.entries[key] = entry
+		a.order = append(a.order, key)
+	}
+}
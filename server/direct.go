@@ -0,0 +1,32 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	pb "program/route"
+	"strconv"
+)
+
+func (s *server) SendDirect(ctx context.Context, in *pb.RequestText) (*pb.GenericText, error) {
+	if in.Recipient == nil {
+		return nil, routeError(codes.InvalidArgument, pb.ErrorReason_MISSING_RECIPIENT, "direct message needs a recipient")
+	}
+	return s.publish(in)
+}
+
+// checkRecipient makes sure a direct message has somewhere to go
+func (s *server) checkRecipient(in *pb.RequestText) error {
+	if !s.isConnected(in.Recipient.GetId()) {
+		return routeError(codes.NotFound, pb.ErrorReason_RECIPIENT_NOT_FOUND, "client "+strconv.FormatInt(in.Recipient.GetId(), 10)+" is not connected")
+	}
+	return nil
+}
+
+// visible reports whether the client may see msg: direct messages only reach
+// their sender and recipient, room messages only the room's members
+func (s *server) visible(msg *pb.GenericText, id int64) bool {
+	if msg.Recipient != nil {
+		return id == msg.Recipient.GetId() || id == msg.Client.GetId()
+	}
+	return s.rooms.has(msg.Room, id)
+}
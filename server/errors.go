@@ -0,0 +1,75 @@
+package main
+
+import (
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "program/route"
+	"strconv"
+)
+
+// Domain of the ErrorInfo attached to every error the server returns
+const errorDomain = "route"
+
+// routeError builds a gRPC status error carrying an ErrorInfo with the reason,
+// so clients can branch on the reason instead of parsing the message
+func routeError(code codes.Code, reason pb.ErrorReason, msg string) error {
+	return detailedError(code, msg, &errdetails.ErrorInfo{Reason: reason.String(), Domain: errorDomain})
+}
+
+func detailedError(code codes.Code, msg string, info *errdetails.ErrorInfo) error {
+	st := status.New(code, msg)
+	if detailed, err := st.WithDetails(info); err == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+func errUnknownClient() error {
+	return routeError(codes.NotFound, pb.ErrorReason_UNKNOWN_CLIENT, "client is not connected to the server")
+}
+
+func errNotAMember(room string) error {
+	return routeError(codes.FailedPrecondition, pb.ErrorReason_NOT_A_MEMBER, "client is not in #"+room)
+}
+
+// errNotLeader sends the client to the leader, named in the "leader" metadata when this server knows it
+func errNotLeader(leader string) error {
+	info := &errdetails.ErrorInfo{Reason: pb.ErrorReason_NOT_LEADER.String(), Domain: errorDomain}
+	msg := "this server is not the leader"
+	if leader != "" {
+		info.Metadata = map[string]string{"leader": leader}
+		msg += ", try " + leader
+	}
+	return detailedError(codes.FailedPrecondition, msg, info)
+}
+
+// errNotSent ends a chat stream on a server that turned text away because it doesn't lead.
+// Besides the leader it names the first message it didn't take in the "request" metadata,
+// so the client knows that one and everything it sent after it never went out.
+func errNotSent(leader string, request *pb.RequestId) error {
+	info := &errdetails.ErrorInfo{Reason: pb.ErrorReason_NOT_LEADER.String(), Domain: errorDomain, Metadata: map[string]string{}}
+	msg := "this server is not the leader, the message was not sent"
+	if leader != "" {
+		info.Metadata["leader"] = leader
+		msg += ", try " + leader
+	}
+	if request != nil {
+		info.Metadata["request"] = strconv.FormatUint(request.Seq, 10)
+	}
+	return detailedError(codes.FailedPrecondition, msg, info)
+}
+
+// isNotLeader reports whether err turned a call away because the server doesn't lead
+func isNotLeader(err error) bool {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok && info.Domain == errorDomain {
+			return info.Reason == pb.ErrorReason_NOT_LEADER.String()
+		}
+	}
+	return false
+}
+
+func errReplicationFailed() error {
+	return routeError(codes.Unavailable, pb.ErrorReason_REPLICATION_FAILED, "the cluster could not confirm the change, it may or may not have been made")
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"google.golang.org/grpc/codes"
+	"log"
+	pb "program/route"
+	"strconv"
+)
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+func (s *server) History(ctx context.Context, in *pb.HistoryRequest) (*pb.HistoryPage, error) {
+	if !s.rooms.has(in.Room, in.Client.GetId()) {
+		return nil, errNotAMember(in.Room)
+	}
+	before, err := decodeCursor(in.Cursor)
+	if err != nil {
+		return nil, routeError(codes.InvalidArgument, pb.ErrorReason_INVALID_CURSOR, "cursor was not returned by History")
+	}
+	size := int(in.PageSize)
+	if size <= 0 {
+		size = defaultPageSize
+	}
+	if size > maxPageSize {
+		size = maxPageSize
+	}
+
+	msgs, more := s.history.page(before, size, func(msg *pb.GenericText) bool {
+		return msg.Kind == pb.EventKind_MESSAGE && msg.Room == in.Room && s.visible(msg, in.Client.GetId())
+	})
+	page := &pb.HistoryPage{Messages: msgs}
+	if more {
+		page.NextCursor = encodeCursor(msgs[0].Seq)
+	}
+
+	log.Println(s.name(in.Client.GetId()) + ": read " + strconv.Itoa(len(msgs)) + " messages of history")
+	return page, nil
+}
+
+// A cursor points just past the oldest message of the page that returned it.
+// Clients should treat it as opaque.
+func encodeCursor(seq uint64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatUint(seq, 10)))
+}
+
+func decodeCursor(cursor string) (uint64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(string(raw), 10, 64)
+}
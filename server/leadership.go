@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+	"google.golang.org/grpc"
+	"log"
+	"program/election"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How often a server of a cluster checks whether it still leads
+const leadershipCheck = 100 * time.Millisecond
+
+// A leadership tells a server of a cluster whether it is the one that takes
+// clients, and where to send them when it is not. Raft nodes and the elections
+// of the election package are leaderships.
+type leadership interface {
+	// IsLeader reports whether this server leads
+	IsLeader() bool
+	// Leader returns the address of the leader, empty while there is none
+	Leader() string
+}
+
+// checkLeader refuses calls only the leader can serve. Leases are only kept on the leader.
+func (s *server) checkLeader() error {
+	if s.leader == nil || s.leader.IsLeader() {
+		return nil
+	}
+	return errNotLeader(s.leader.Leader())
+}
+
+// redirect sends every client call to the leader when the followers don't have
+// its state, as with an election alone. Raft followers have it and serve reads.
+func (s *server) redirect() error {
+	if s.raft != nil {
+		return nil
+	}
+	return s.checkLeader()
+}
+
+// watchLeadership checks every interval whether the server still leads, forever.
+// A server that stops leading ends every open stream, and its clients go to the
+// new leader instead of chatting on among themselves.
+func (s *server) watchLeadership(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	leading := s.leader.IsLeader()
+	for range ticker.C {
+		wasLeading := leading
+		leading = s.leader.IsLeader()
+		if wasLeading && !leading {
+			log.Println("No longer the leader, ending every stream")
+			s.kickAll()
+		}
+	}
+}
+
+// startElection has the server take part in the election cfg asks for and
+// serves the Peer RPCs on g. The returned function stops taking part.
+func (s *server) startElection(g *grpc.Server, dial grpc.DialOption) (func(), error) {
+	group, err := cfg.group()
+	if err != nil {
+		return nil, err
+	}
+	var self election.Peer
+	for _, p := range group {
+		if p.ID == cfg.NodeID {
+			self = p
+		}
+	}
+
+	var elector interface {
+		leadership
+		Register(*grpc.Server)
+		Start()
+		Stop()
+	}
+	switch cfg.Election {
+	case "ring":
+		elector = election.NewRing(self, group, cfg.ElectionTimeout, dial)
+	default:
+		elector = election.NewBully(self, group, cfg.ElectionTimeout, dial)
+	}
+	elector.Register(g)
+	elector.Start()
+	s.leader = elector
+	log.Println("Taking part in the " + cfg.Election + " election as node " + strconv.FormatInt(self.ID, 10) + " of " + cfg.Peers)
+	return elector.Stop, nil
+}
+
+// group parses peers, a comma separated list of id=address
+func (c config) group() ([]election.Peer, error) {
+	var group []election.Peer
+	for _, entry := range strings.Split(c.Peers, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.Index(entry, "=")
+		if i < 0 {
+			return nil, errors.New("peers: " + strconv.Quote(entry) + " is not id=address")
+		}
+		id, err := strconv.ParseInt(entry[:i], 10, 64)
+		if err != nil || id <= 0 {
+			return nil, errors.New("peers: " + strconv.Quote(entry[:i]) + " is not a positive id")
+		}
+		group = append(group, election.Peer{ID: id, Addr: entry[i+1:]})
+	}
+	return group, nil
+}
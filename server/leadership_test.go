@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	pb "program/route"
+	"sync"
+	"testing"
+	"time"
+)
+
+// switchLeader is a leadership the test hands over by hand
+type switchLeader struct {
+	mu      sync.Mutex
+	leading bool
+	leader  string
+}
+
+func (l *switchLeader) IsLeader() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leading
+}
+
+func (l *switchLeader) Leader() string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.leader
+}
+
+// handOver makes leader the leader, and this server a follower
+func (l *switchLeader) handOver(leader string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.leading = false
+	l.leader = leader
+}
+
+// chatOnLeader serves s and opens a chat stream for alice, returning once she is welcomed
+func chatOnLeader(t *testing.T, s *server) (*pb.Client, pb.Route_ChatClient) {
+	t.Helper()
+	client := serveRoute(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	t.Cleanup(cancel)
+	ack, err := client.Connect(ctx, &pb.ConnectRequest{Name: "alice"})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	authed := metadata.AppendToOutgoingContext(ctx, tokenKey, ack.Token)
+	stream, err := client.Chat(authed)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if err := stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Send{Send: &pb.RequestText{Client: ack.Client}}}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	return ack.Client, stream
+}
+
+// streamEnd reads from the stream until it ends and returns why
+func streamEnd(stream pb.Route_ChatClient) error {
+	for {
+		if _, err := stream.Recv(); err != nil {
+			return err
+		}
+	}
+}
+
+func TestDeposedLeaderEndsItsStreams(t *testing.T) {
+	s := newServer(time.Now)
+	lead := &switchLeader{leading: true, leader: "localhost:7001"}
+	s.leader = lead
+	go s.watchLeadership(10 * time.Millisecond)
+	alice, stream := chatOnLeader(t, s)
+
+	//A higher server came back and took over
+	lead.handOver("localhost:7002")
+	if err := streamEnd(stream); reasonOf(err) != pb.ErrorReason_NOT_LEADER.String() {
+		t.Fatalf("stream ended with %v, want NOT_LEADER", err)
+	}
+
+	//Nothing gets through it any more, whichever way it comes
+	if err := s.broadcast(&pb.GenericText{Body: "still here", Kind: pb.EventKind_NOTICE}); reasonOf(err) != pb.ErrorReason_NOT_LEADER.String() {
+		t.Fatalf("broadcast on a follower: %v", err)
+	}
+	if _, err := s.publish(&pb.RequestText{Body: "hello", Client: alice}); reasonOf(err) != pb.ErrorReason_NOT_LEADER.String() {
+		t.Fatalf("publish on a follower: %v", err)
+	}
+}
+
+func TestTextTurnedAwayEndsTheStream(t *testing.T) {
+	s := newServer(time.Now)
+	lead := &switchLeader{leading: true, leader: "localhost:7001"}
+	s.leader = lead
+	alice, stream := chatOnLeader(t, s)
+
+	//Nothing watches the leadership here, the text is what finds out
+	lead.handOver("localhost:7002")
+	text := &pb.RequestText{Body: "hello", Client: alice, RequestId: &pb.RequestId{Client: alice.Id, Seq: 7}}
+	if err := stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Send{Send: text}}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	err := streamEnd(stream)
+	if reasonOf(err) != pb.ErrorReason_NOT_LEADER.String() {
+		t.Fatalf("stream ended with %v, want NOT_LEADER", err)
+	}
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			if info.Metadata["leader"] != "localhost:7002" || info.Metadata["request"] != "7" {
+				t.Fatalf("metadata = %v, want the leader and request 7", info.Metadata)
+			}
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// leases tracks when each connected client's membership runs out. Clients
+// extend their lease with heartbeats; the reaper evicts the ones that lapse.
+type leases struct {
+	mu     sync.Mutex
+	ttl    time.Duration
+	now    func() time.Time
+	expiry map[int64]time.Time
+}
+
+// newLeases makes a lease table reading time from now, so tests can drive the clock.
+func newLeases(ttl time.Duration, now func() time.Time) *leases {
+	return &leases{
+		ttl:    ttl,
+		now:    now,
+		expiry: make(map[int64]time.Time),
+	}
+}
+
+// grant starts a fresh lease for the client.
+func (l *leases) grant(id int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.expiry[id] = l.now().Add(l.ttl)
+}
+
+// renew extends the client's lease. It reports false if the client holds no lease.
+func (l *leases) renew(id int64) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, ok := l.expiry[id]; !ok {
+		return false
+	}
+	l.expiry[id] = l.now().Add(l.ttl)
+	return true
+}
+
+func (l *leases) revoke(id int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.expiry, id)
+}
+
+// expired removes and returns every client whose lease has lapsed.
+func (l *leases) expired() []int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	var lapsed []int64
+	for id, at := range l.expiry {
+		if !now.Before(at) {
+			lapsed = append(lapsed, id)
+			delete(l.expiry, id)
+		}
+	}
+	return lapsed
+}
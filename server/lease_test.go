@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	pb "program/route"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock is a clock the test moves by hand
+type fakeClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func TestLeaseExpiry(t *testing.T) {
+	clock := newFakeClock()
+	l := newLeases(10*time.Second, clock.Now)
+	l.grant(1)
+	l.grant(2)
+
+	clock.Advance(9 * time.Second)
+	if lapsed := l.expired(); len(lapsed) != 0 {
+		t.Fatalf("expired before the ttl: %v", lapsed)
+	}
+	if !l.renew(1) {
+		t.Fatal("renew of a live lease failed")
+	}
+
+	clock.Advance(time.Second)
+	lapsed := l.expired()
+	if len(lapsed) != 1 || lapsed[0] != 2 {
+		t.Fatalf("expired = %v, want [2]", lapsed)
+	}
+	if l.renew(2) {
+		t.Fatal("renewed a lease that expired")
+	}
+	if lapsed := l.expired(); len(lapsed) != 0 {
+		t.Fatalf("an expired lease was reported twice: %v", lapsed)
+	}
+
+	clock.Advance(9 * time.Second)
+	if lapsed := l.expired(); len(lapsed) != 1 || lapsed[0] != 1 {
+		t.Fatalf("expired = %v, want [1] once its renewal ran out", lapsed)
+	}
+}
+
+func TestLeaseRevoke(t *testing.T) {
+	clock := newFakeClock()
+	l := newLeases(time.Second, clock.Now)
+	l.grant(1)
+	l.revoke(1)
+	clock.Advance(time.Hour)
+	if lapsed := l.expired(); len(lapsed) != 0 {
+		t.Fatalf("a revoked lease expired: %v", lapsed)
+	}
+	if l.renew(1) {
+		t.Fatal("renewed a revoked lease")
+	}
+}
+
+func TestReapOnceEvicts(t *testing.T) {
+	clock := newFakeClock()
+	s := newServer(clock.Now)
+	ctx := context.Background()
+	for _, name := range []string{"alice", "bob"} {
+		if _, err := s.Connect(ctx, &pb.ConnectRequest{Name: name}); err != nil {
+			t.Fatalf("connect %s: %v", name, err)
+		}
+	}
+	alice, _ := s.clients.owner("alice")
+	bob, _ := s.clients.owner("bob")
+
+	clock.Advance(cfg.LeaseTTL / 2)
+	if _, err := s.Heartbeat(ctx, &pb.Client{Id: alice}); err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+	clock.Advance(cfg.LeaseTTL / 2)
+	s.reapOnce()
+
+	if !s.isConnected(alice) {
+		t.Error("alice sent a heartbeat and was evicted")
+	}
+	if s.isConnected(bob) {
+		t.Error("bob missed every heartbeat and is still connected")
+	}
+	if _, err := s.Heartbeat(ctx, &pb.Client{Id: bob}); err == nil {
+		t.Error("an evicted client could still renew its lease")
+	}
+	//Everyone is told bob left
+	history := s.history.all()
+	last := history[len(history)-1]
+	if last.Kind != pb.EventKind_LEAVE || last.Client.GetId() != bob {
+		t.Errorf("last broadcast = %v, want bob leaving", last)
+	}
+}
+
+func TestEvictedClientsRetryIsNotBroadcastAgain(t *testing.T) {
+	clock := newFakeClock()
+	s := newServer(clock.Now)
+	ctx := context.Background()
+	ack, err := s.Connect(ctx, &pb.ConnectRequest{Name: "bob"})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	bob := ack.Client
+	request := &pb.RequestId{Client: bob.Id, Seq: 1}
+	first, err := s.publish(&pb.RequestText{Body: "hi", Client: bob, RequestId: request})
+	if err != nil {
+		t.Fatalf("publish: %v", err)
+	}
+
+	//bob is offline for longer than his lease and comes back as a new session
+	clock.Advance(cfg.LeaseTTL)
+	s.reapOnce()
+	if s.isConnected(bob.Id) {
+		t.Fatal("bob was not evicted")
+	}
+	if _, err := s.Connect(ctx, &pb.ConnectRequest{Id: bob.Id, Name: "bob"}); err != nil {
+		t.Fatalf("connect again: %v", err)
+	}
+	retry, err := s.publish(&pb.RequestText{Body: "hi", Client: bob, RequestId: request})
+	if err != nil {
+		t.Fatalf("retry: %v", err)
+	}
+	if retry.Seq != first.Seq {
+		t.Fatalf("the retry was broadcast again as #%d, first as #%d", retry.Seq, first.Seq)
+	}
+
+	//Saying goodbye is the end of it
+	if _, err := s.Disconnect(ctx, bob); err != nil {
+		t.Fatalf("disconnect: %v", err)
+	}
+	if _, ok := s.answered.get(request); ok {
+		t.Fatal("bob's requests are remembered after he said goodbye")
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"log"
+	pb "program/route"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// The longest display name the server accepts
+const maxName = 32
+
+func (s *server) Rename(ctx context.Context, in *pb.RenameRequest) (*pb.Acknowledgement, error) {
+	name := strings.TrimSpace(in.Name)
+	if err := checkName(name); err != nil {
+		return nil, err
+	}
+	if cn := certName(ctx); cn != "" {
+		return nil, routeError(codes.PermissionDenied, pb.ErrorReason_INVALID_NAME, "the name comes from the client certificate")
+	}
+	if s.auth != nil && s.auth.Identifies() {
+		return nil, routeError(codes.PermissionDenied, pb.ErrorReason_INVALID_NAME, "the name is the user logged in as")
+	}
+	old := s.name(in.Client.GetId())
+	if err := s.commit(&pb.Record{Op: &pb.Record_Rename{Rename: &pb.RenameRequest{Client: in.Client, Name: name}}}); err != nil {
+		return nil, err
+	}
+
+	log.Println(old + ": is now known as " + name)
+	profile, _ := s.clients.get(in.Client.GetId())
+	if err := s.broadcast(&pb.GenericText{Body: old + " is now known as " + name, Client: profile, Kind: pb.EventKind_RENAME}); err != nil {
+		return nil, err
+	}
+	return &pb.Acknowledgement{Status: "You are now known as " + name, Client: profile}, nil
+}
+
+// name returns the client's display name, falling back to its id
+func (s *server) name(id int64) string {
+	if profile, ok := s.clients.get(id); ok {
+		return profile.Name
+	}
+	return defaultName(id)
+}
+
+// profile returns the registered profile of the client, or the bare id if it never connected
+func (s *server) profile(client *pb.Client) *pb.Client {
+	if profile, ok := s.clients.get(client.GetId()); ok {
+		return profile
+	}
+	return &pb.Client{Id: client.GetId(), Name: defaultName(client.GetId())}
+}
+
+func defaultName(id int64) string {
+	return "Client " + strconv.FormatInt(id, 10)
+}
+
+func checkName(name string) error {
+	if name == "" || len(name) > maxName || strings.IndexFunc(name, unicode.IsControl) >= 0 {
+		return routeError(codes.InvalidArgument, pb.ErrorReason_INVALID_NAME, "names are 1 to "+strconv.Itoa(maxName)+" printable characters")
+	}
+	return nil
+}
+
+func errNameTaken(name string) error {
+	return routeError(codes.AlreadyExists, pb.ErrorReason_NAME_TAKEN, "the name "+name+" is taken")
+}
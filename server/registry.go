@@ -0,0 +1,129 @@
+package main
+
+import (
+	"google.golang.org/protobuf/proto"
+	pb "program/route"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// registry holds the connected clients keyed by id, with their names indexed
+// ignoring case. It is safe for concurrent use; every method hands out copies
+// so callers can't change it behind its back.
+type registry struct {
+	mu      sync.Mutex
+	clients map[int64]*pb.Client
+	names   map[string]int64
+}
+
+func newRegistry() *registry {
+	return &registry{clients: make(map[int64]*pb.Client), names: make(map[string]int64)}
+}
+
+// add registers the client. It reports false, and changes nothing, if the id or name is taken.
+func (r *registry) add(client *pb.Client) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.clients[client.GetId()]; ok {
+		return false
+	}
+	if _, ok := r.names[nameKey(client.GetName())]; ok {
+		return false
+	}
+	r.clients[client.GetId()] = proto.Clone(client).(*pb.Client)
+	r.names[nameKey(client.GetName())] = client.GetId()
+	return true
+}
+
+// remove unregisters the client. It reports false if it was not registered.
+func (r *registry) remove(id int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[id]
+	if !ok {
+		return false
+	}
+	delete(r.names, nameKey(client.GetName()))
+	delete(r.clients, id)
+	return true
+}
+
+// rename gives the client a new name. It reports false if the client is not
+// registered or someone else has the name.
+func (r *registry) rename(id int64, name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[id]
+	if !ok {
+		return false
+	}
+	if owner, ok := r.names[nameKey(name)]; ok && owner != id {
+		return false
+	}
+	delete(r.names, nameKey(client.GetName()))
+	client.Name = name
+	r.names[nameKey(name)] = id
+	return true
+}
+
+// owner returns the id of the client using name, ignoring case
+func (r *registry) owner(name string) (int64, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	id, ok := r.names[nameKey(name)]
+	return id, ok
+}
+
+func nameKey(name string) string {
+	return strings.ToLower(name)
+}
+
+func (r *registry) get(id int64) (*pb.Client, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	client, ok := r.clients[id]
+	if !ok {
+		return nil, false
+	}
+	return proto.Clone(client).(*pb.Client), true
+}
+
+func (r *registry) has(id int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, ok := r.clients[id]
+	return ok
+}
+
+// list returns the connected clients sorted by id
+func (r *registry) list() []*pb.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]*pb.Client, 0, len(r.clients))
+	for _, client := range r.clients {
+		list = append(list, proto.Clone(client).(*pb.Client))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].GetId() < list[j].GetId() })
+	return list
+}
+
+// snapshot returns a copy of the whole registry
+func (r *registry) snapshot() map[int64]*pb.Client {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	snap := make(map[int64]*pb.Client, len(r.clients))
+	for id, client := range r.clients {
+		snap[id] = proto.Clone(client).(*pb.Client)
+	}
+	return snap
+}
+
+// ids returns the connected client ids in order, for the log
+func (r *registry) ids() []int64 {
+	var ids []int64
+	for _, client := range r.list() {
+		ids = append(ids, client.GetId())
+	}
+	return ids
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	pb "program/route"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// connectAll sends every request to Connect at once and returns the clients that got in
+func connectAll(t *testing.T, s *server, requests []*pb.ConnectRequest) []*pb.Client {
+	t.Helper()
+	var (
+		mu        sync.Mutex
+		connected []*pb.Client
+		wg        sync.WaitGroup
+	)
+	start := make(chan struct{})
+	for _, in := range requests {
+		wg.Add(1)
+		go func(in *pb.ConnectRequest) {
+			defer wg.Done()
+			<-start
+			ack, err := s.Connect(context.Background(), in)
+			if err != nil {
+				if status.Code(err) != codes.AlreadyExists {
+					t.Errorf("connect %v: %v", in, err)
+				}
+				return
+			}
+			mu.Lock()
+			connected = append(connected, ack.Client)
+			mu.Unlock()
+		}(in)
+	}
+	close(start)
+	wg.Wait()
+	return connected
+}
+
+// checkUnique fails the test if two registered clients share an id or a name
+func checkUnique(t *testing.T, s *server) {
+	t.Helper()
+	names := make(map[string]int64)
+	for id, client := range s.clients.snapshot() {
+		if id != client.Id {
+			t.Errorf("client %v is registered under id %d", client, id)
+		}
+		if other, ok := names[nameKey(client.Name)]; ok {
+			t.Errorf("clients %d and %d are both called %s", other, id, client.Name)
+		}
+		names[nameKey(client.Name)] = id
+	}
+}
+
+func TestConcurrentConnectsWithTheSameName(t *testing.T) {
+	s := newServer(time.Now)
+	var requests []*pb.ConnectRequest
+	for i := 0; i < 50; i++ {
+		//Names only differ in case, which doesn't make them different
+		name := "alice"
+		if i%2 == 1 {
+			name = strings.ToUpper(name)
+		}
+		requests = append(requests, &pb.ConnectRequest{Name: name})
+	}
+	if connected := connectAll(t, s, requests); len(connected) != 1 {
+		t.Fatalf("%d clients got the same name: %v", len(connected), connected)
+	}
+	checkUnique(t, s)
+}
+
+func TestConcurrentConnectsWithTheSameID(t *testing.T) {
+	s := newServer(time.Now)
+	var requests []*pb.ConnectRequest
+	for i := 0; i < 50; i++ {
+		requests = append(requests, &pb.ConnectRequest{Id: 7, Name: fmt.Sprintf("client%d", i)})
+	}
+	if connected := connectAll(t, s, requests); len(connected) != 1 {
+		t.Fatalf("%d clients got id 7: %v", len(connected), connected)
+	}
+	checkUnique(t, s)
+}
+
+func TestConcurrentConnectsGetTheirOwnIDs(t *testing.T) {
+	s := newServer(time.Now)
+	var requests []*pb.ConnectRequest
+	for i := 0; i < 50; i++ {
+		requests = append(requests, &pb.ConnectRequest{})
+	}
+	connected := connectAll(t, s, requests)
+	if len(connected) != len(requests) {
+		t.Fatalf("%d of %d clients got in without picking an id or a name", len(connected), len(requests))
+	}
+	ids := make(map[int64]bool)
+	for _, client := range connected {
+		if ids[client.Id] {
+			t.Errorf("id %d was handed out twice", client.Id)
+		}
+		ids[client.Id] = true
+	}
+	checkUnique(t, s)
+}
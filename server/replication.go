@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	"log"
+	"path/filepath"
+	"program/clock"
+	"program/raft"
+	pb "program/route"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// startRaft makes the server a node of its cluster and serves the Raft RPCs on g.
+// The server's state then comes from the Raft log instead of the write-ahead log.
+// The returned function stops the node.
+func (s *server) startRaft(g *grpc.Server, dial grpc.DialOption) (func(), error) {
+	var storage raft.Storage = raft.NewMemoryStorage()
+	var files *raft.FileStorage
+	if cfg.DataDir != "" {
+		var err error
+		files, err = raft.OpenFileStorage(filepath.Join(cfg.DataDir, "raft"), cfg.SegmentSize)
+		if err != nil {
+			return nil, err
+		}
+		storage = files
+	}
+	transport := raft.NewGRPCTransport(dial)
+	node, err := raft.NewNode(raft.Config{
+		ID:                cfg.advertise(),
+		Peers:             cfg.peers(),
+		Storage:           storage,
+		Transport:         transport,
+		ElectionTimeout:   cfg.RaftElectionTimeout,
+		HeartbeatInterval: cfg.RaftHeartbeat,
+		SnapshotEvery:     uint64(cfg.RaftSnapshotEvery),
+	}, machine{s})
+	if err != nil {
+		if files != nil {
+			files.Close()
+		}
+		return nil, err
+	}
+	s.raft = node
+	s.leader = node
+	raft.Register(g, node)
+	if cfg.RaftJoin != "" {
+		go join(transport, cfg.RaftJoin, cfg.advertise())
+	}
+	if members := node.Membership(); len(members) > 0 {
+		log.Println("Raft node " + cfg.advertise() + " of " + strings.Join(members, ", "))
+	} else {
+		log.Println("Raft node " + cfg.advertise() + ", waiting to be added to the cluster")
+	}
+
+	return func() {
+		node.Stop()
+		transport.Close()
+		if files != nil {
+			if err := files.Close(); err != nil {
+				log.Printf("could not flush raft log: %v", err)
+			}
+		}
+	}, nil
+}
+
+// join asks the cluster seed belongs to to take this server in, until it does
+func join(transport *raft.GRPCTransport, seed, self string) {
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		membership, err := transport.Join(ctx, seed, self)
+		cancel()
+		if err == nil {
+			log.Println("Joined the cluster of " + strings.Join(membership.Voters, ", "))
+			return
+		}
+		log.Printf("could not join the cluster through %s: %v", seed, err)
+		time.Sleep(time.Second)
+	}
+}
+
+// replicate hands the change to Raft and waits until it has been applied here.
+// Only the leader takes changes, followers send the client to it.
+func (s *server) replicate(rec *pb.Record) error {
+	if !s.raft.IsLeader() {
+		return errNotLeader(s.raft.Leader())
+	}
+	s.applyMu.Lock()
+	err := s.prepare(rec)
+	s.applyMu.Unlock()
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.RaftCommitTimeout)
+	defer cancel()
+	result, err := s.raft.Propose(ctx, data)
+	switch {
+	case errors.Is(err, raft.ErrNotLeader):
+		return errNotLeader(s.raft.Leader())
+	case err != nil:
+		log.Printf("could not replicate change: %v", err)
+		return errReplicationFailed()
+	}
+
+	//The change was applied from its own copy, bring back what applying it filled in
+	switch applied := result.(type) {
+	case error:
+		return applied
+	case *pb.Record:
+		if msg := rec.GetBroadcast(); msg != nil {
+			msg.Seq = applied.GetBroadcast().GetSeq()
+		}
+	}
+	return nil
+}
+
+// machine is the server's state as Raft replicates it. Every server applies
+// the committed records in the same order.
+type machine struct {
+	s *server
+}
+
+func (m machine) Apply(index uint64, data []byte) interface{} {
+	rec := &pb.Record{}
+	if err := proto.Unmarshal(data, rec); err != nil {
+		return err
+	}
+	m.s.applyMu.Lock()
+	defer m.s.applyMu.Unlock()
+	//Checked again, a change from an earlier leader may have landed since the leader checked it
+	if err := m.s.validate(rec); err != nil {
+		return err
+	}
+	m.s.apply(rec)
+	return rec
+}
+
+func (m machine) Snapshot() ([]byte, error) {
+	s := m.s
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+	state := &pb.State{Rooms: s.rooms.list(), History: s.history.all(), LastId: s.lastID, Lamport: s.clock.Now(), Answered: s.answered.list()}
+	state.Seq, state.Lobby = s.lastLobby()
+	for _, client := range s.clients.list() {
+		state.Clients = append(state.Clients, &pb.ConnectRequest{Id: client.Id, Name: client.Name, Status: client.Status, Token: s.sessions.token(client.Id)})
+	}
+	return proto.Marshal(state)
+}
+
+func (m machine) Restore(data []byte) error {
+	state := &pb.State{}
+	if err := proto.Unmarshal(data, state); err != nil {
+		return err
+	}
+	s := m.s
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+
+	for id := range s.clients.snapshot() {
+		s.forget(id)
+	}
+	for _, c := range state.Clients {
+		s.clients.add(&pb.Client{Id: c.Id, Name: c.Name, Status: c.Status})
+		s.leases.grant(c.Id)
+		s.sessions.grant(c.Token, c.Id)
+	}
+	for _, room := range state.Rooms {
+		for _, id := range room.Members {
+			s.rooms.join(room.Name, id)
+		}
+	}
+
+	s.answered.replace(state.Answered)
+	s.history.replace(state.History)
+	s.retransmit.reset()
+	recent := state.History
+	if len(recent) > cfg.RetransmitSize {
+		recent = recent[len(recent)-cfg.RetransmitSize:]
+	}
+	for _, msg := range recent {
+		s.retransmit.add(msg)
+	}
+	s.mu.Lock()
+	s.seq = state.Seq
+	s.lobby = make(clock.Vector)
+	s.lobby.Merge(state.Lobby)
+	s.mu.Unlock()
+	s.lastID = state.LastId
+	s.clock.Advance(state.Lamport)
+
+	log.Println("Restored " + strconv.Itoa(len(state.Clients)) + " clients from a snapshot, last broadcast #" + strconv.FormatUint(state.Seq, 10))
+	return nil
+}
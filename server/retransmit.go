@@ -0,0 +1,55 @@
+package main
+
+import (
+	pb "program/route"
+	"sync"
+)
+
+// retransmitBuffer keeps the most recent broadcasts so clients that notice a
+// gap in the sequence numbers can fetch what they missed.
+type retransmitBuffer struct {
+	mu   sync.Mutex
+	msgs []*pb.GenericText
+	next int
+	size int
+}
+
+func newRetransmitBuffer(size int) *retransmitBuffer {
+	return &retransmitBuffer{msgs: make([]*pb.GenericText, 0, size), size: size}
+}
+
+// add stores msg, pushing out the oldest one when the buffer is full.
+// Messages must be added in sequence order.
+func (b *retransmitBuffer) add(msg *pb.GenericText) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if len(b.msgs) < b.size {
+		b.msgs = append(b.msgs, msg)
+		return
+	}
+	b.msgs[b.next] = msg
+	b.next = (b.next + 1) % b.size
+}
+
+// reset empties the buffer
+func (b *retransmitBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.msgs = b.msgs[:0]
+	b.next = 0
+}
+
+// get returns the buffered messages with from <= seq <= to, oldest first.
+// Messages that have already been pushed out are simply missing.
+func (b *retransmitBuffer) get(from, to uint64) []*pb.GenericText {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var found []*pb.GenericText
+	for i := 0; i < len(b.msgs); i++ {
+		msg := b.msgs[(b.next+i)%len(b.msgs)]
+		if msg.Seq >= from && msg.Seq <= to {
+			found = append(found, msg)
+		}
+	}
+	return found
+}
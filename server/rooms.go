@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc/codes"
+	"log"
+	pb "program/route"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The longest room name the server accepts
+const maxRoomName = 32
+
+// rooms is the server's registry of named rooms and their members. A room
+// exists while it has members. Messages without a room go to everyone.
+type rooms struct {
+	mu      sync.Mutex
+	members map[string]map[int64]bool
+}
+
+func newRooms() *rooms {
+	return &rooms{members: make(map[string]map[int64]bool)}
+}
+
+// join adds the client to the room, creating it if needed. It reports false if the client was already in it.
+func (r *rooms) join(room string, id int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.members[room] == nil {
+		r.members[room] = make(map[int64]bool)
+	}
+	if r.members[room][id] {
+		return false
+	}
+	r.members[room][id] = true
+	return true
+}
+
+// leave removes the client from the room. It reports false if the client was not in it.
+func (r *rooms) leave(room string, id int64) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.members[room][id] {
+		return false
+	}
+	delete(r.members[room], id)
+	if len(r.members[room]) == 0 {
+		delete(r.members, room)
+	}
+	return true
+}
+
+// leaveAll removes the client from every room it is in
+func (r *rooms) leaveAll(id int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for room, members := range r.members {
+		delete(members, id)
+		if len(members) == 0 {
+			delete(r.members, room)
+		}
+	}
+}
+
+// has reports whether the client may see messages sent to room
+func (r *rooms) has(room string, id int64) bool {
+	if room == "" {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.members[room][id]
+}
+
+// list returns every room with its members, sorted by name
+func (r *rooms) list() []*pb.Room {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	list := make([]*pb.Room, 0, len(r.members))
+	for name, members := range r.members {
+		room := &pb.Room{Name: name}
+		for id := range members {
+			room.Members = append(room.Members, id)
+		}
+		sort.Slice(room.Members, func(i, j int) bool { return room.Members[i] < room.Members[j] })
+		list = append(list, room)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+func (s *server) JoinRoom(ctx context.Context, in *pb.RoomRequest) (*pb.Acknowledgement, error) {
+	if err := s.checkRoomRequest(in); err != nil {
+		return nil, err
+	}
+	if s.rooms.has(in.Room, in.Client.GetId()) {
+		return &pb.Acknowledgement{Status: "Already in #" + in.Room}, nil
+	}
+	if err := s.commit(&pb.Record{Op: &pb.Record_JoinRoom{JoinRoom: in}}); err != nil {
+		return nil, err
+	}
+
+	profile := s.profile(in.Client)
+	log.Println(profile.Name + ": joined #" + in.Room)
+	if err := s.broadcast(&pb.GenericText{Body: profile.Name + " joined #" + in.Room, Client: profile, Kind: pb.EventKind_JOIN, Room: in.Room}); err != nil {
+		return nil, err
+	}
+	return &pb.Acknowledgement{Status: "Joined #" + in.Room}, nil
+}
+
+func (s *server) LeaveRoom(ctx context.Context, in *pb.RoomRequest) (*pb.Acknowledgement, error) {
+	if err := s.checkRoomRequest(in); err != nil {
+		return nil, err
+	}
+	if !s.rooms.has(in.Room, in.Client.GetId()) {
+		return nil, errNotAMember(in.Room)
+	}
+
+	//Tell the room before leaving so the client sees it go too
+	profile := s.profile(in.Client)
+	if err := s.broadcast(&pb.GenericText{Body: profile.Name + " left #" + in.Room, Client: profile, Kind: pb.EventKind_LEAVE, Room: in.Room}); err != nil {
+		return nil, err
+	}
+	if err := s.commit(&pb.Record{Op: &pb.Record_LeaveRoom{LeaveRoom: in}}); err != nil {
+		return nil, err
+	}
+	log.Println(profile.Name + ": left #" + in.Room)
+	return &pb.Acknowledgement{Status: "Left #" + in.Room}, nil
+}
+
+func (s *server) ListRooms(ctx context.Context, in *pb.Client) (*pb.RoomList, error) {
+	return &pb.RoomList{Rooms: s.rooms.list()}, nil
+}
+
+func (s *server) checkRoomRequest(in *pb.RoomRequest) error {
+	if !s.isConnected(in.Client.GetId()) {
+		return errUnknownClient()
+	}
+	if in.Room == "" || len(in.Room) > maxRoomName || strings.ContainsAny(in.Room, " \t\n") {
+		return routeError(codes.InvalidArgument, pb.ErrorReason_INVALID_ROOM, "room names are 1 to "+strconv.Itoa(maxRoomName)+" characters without spaces")
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"google.golang.org/grpc"
+	"net"
+	pb "program/route"
+	"testing"
+)
+
+// routeServer makes a gRPC server for s with its auth interceptors and the Route
+// service registered. opts come after the interceptors, so unary interceptors
+// chained in through them run once the call is authenticated.
+func routeServer(s *server, opts ...grpc.ServerOption) *grpc.Server {
+	opts = append([]grpc.ServerOption{grpc.UnaryInterceptor(s.authUnary), grpc.StreamInterceptor(s.authStream)}, opts...)
+	g := grpc.NewServer(opts...)
+	pb.RegisterRouteServer(g, s)
+	return g
+}
+
+// serve serves g on a free local port until the test ends and returns its address
+func serve(t *testing.T, g *grpc.Server) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go g.Serve(lis)
+	t.Cleanup(g.Stop)
+	return lis.Addr().String()
+}
+
+// dialRoute connects to addr until the test ends
+func dialRoute(t *testing.T, addr string, opt grpc.DialOption) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.Dial(addr, opt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// serveRoute serves s in plaintext on a free local port and returns a client of it
+func serveRoute(t *testing.T, s *server) pb.RouteClient {
+	t.Helper()
+	return pb.NewRouteClient(dialRoute(t, serve(t, routeServer(s)), grpc.WithInsecure()))
+}
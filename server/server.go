@@ -2,79 +2,629 @@ package main
 
 import (
 	"context"
-	"flag"
+	"errors"
+	"fmt"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
 	"log"
 	"net"
+	"os"
+	"os/signal"
+	"program/certs"
+	"program/clock"
+	"program/raft"
 	pb "program/route"
+	"program/wal"
 	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
-var port = flag.String("port", "8080", "The docker port of the server")
-
 type server struct {
 	pb.UnimplementedRouteServer
-	connectedClients []string
+	clients  *registry
+	sessions *sessions
+	//Checks credentials on Connect, nil lets everyone in
+	auth Authenticator
+
+	//Outbound channel per subscribed client, keyed by client id
+	mu          sync.Mutex
+	subscribers map[int64]chan *pb.GenericText
+	//Sequence number of the last broadcast each subscriber could see
+	seen map[int64]uint64
+	//Set once shutdown begins, guarded by mu
+	stopping bool
+	//Clients whose stream ended without a Disconnect and that haven't opened another, guarded by mu
+	dropped map[int64]bool
+
+	//Sequence number of the last broadcast, and the most recent broadcasts for Fetch
+	seq        uint64
+	retransmit *retransmitBuffer
+	//Every lobby message's vector clock merged, guarded by mu. Causal clients start counting from it
+	lobby clock.Vector
+
+	//Every broadcast so far, for History
+	history messageStore
+
+	//What messages sent with a request id broadcast, so retries aren't broadcast again
+	answered *answeredRequests
+
+	rooms *rooms
+
+	leases *leases
+
+	//Logical time of the server, stamped on everything it sends
+	clock clock.Lamport
+
+	//State changes are committed one at a time, to the log first when there is one,
+	//or through the cluster's leader when the server is part of one
+	commitMu sync.Mutex
+	wal      *wal.Log
+	raft     *raft.Node
+
+	//Which server of the cluster takes clients, nil when the server runs alone
+	leader leadership
+
+	//Held while a change is checked or applied, so changes arriving from the leader
+	//can't land in between. Guards lastID
+	applyMu sync.Mutex
+	//Highest client id seen so far
+	lastID int64
 }
 
-type argError struct {
-	_type string
-	_desc string
+func (s *server) BroadcastMessage(ctx context.Context, in *pb.RequestText) (*pb.GenericText, error) {
+	return s.publish(in)
 }
 
-func (e *argError) Error() string {
-	return e._type + ": " + e._desc
+func (s *server) Subscribe(in *pb.Client, stream pb.Route_SubscribeServer) error {
+	ch := s.subscribe(in.GetId())
+	defer s.unsubscribe(in.GetId(), ch)
+	log.Println(s.name(in.GetId()) + ": has subscribed")
+
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				if s.isStopping() {
+					return errShuttingDown()
+				}
+				//The server lost the lead, the client subscribes again with the leader
+				if err := s.checkLeader(); err != nil {
+					return err
+				}
+				return nil
+			}
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
 }
 
-func (s *server) BroadcastMessage(ctx context.Context, in *pb.RequestText) (*pb.GenericText, error) {
-	return &pb.GenericText{Body: in.Body}, nil
+// subscribe registers a fresh outbound channel for the client, replacing any older one.
+// Once the server is stopping the channel comes back closed.
+func (s *server) subscribe(id int64) chan *pb.GenericText {
+	ch := make(chan *pb.GenericText, cfg.SubscriberBuffer)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		close(ch)
+		return ch
+	}
+	s.subscribers[id] = ch
+	s.seen[id] = s.seq
+	delete(s.dropped, id)
+	return ch
+}
+
+// unsubscribe removes the channel unless the client has already resubscribed with a newer one.
+func (s *server) unsubscribe(id int64, ch chan *pb.GenericText) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.subscribers[id] != ch {
+		return
+	}
+	delete(s.subscribers, id)
+	delete(s.seen, id)
+	//A client that said goodbye is gone already
+	if s.clients.has(id) {
+		s.dropped[id] = true
+	}
+}
+
+// kick drops the client's subscription and closes its channel, which ends its stream
+func (s *server) kick(id int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if ch, ok := s.subscribers[id]; ok {
+		delete(s.subscribers, id)
+		delete(s.seen, id)
+		close(ch)
+	}
+}
+
+// kickAll drops every subscription and closes its channel, which ends every stream
+func (s *server) kickAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for id, ch := range s.subscribers {
+		delete(s.subscribers, id)
+		delete(s.seen, id)
+		close(ch)
+	}
+}
+
+// streamDropped reports whether the client's stream ended and it hasn't opened another
+func (s *server) streamDropped(id int64) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped[id]
+}
+
+func (s *server) lastSeq() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq
+}
+
+// lastLobby returns the sequence number of the last broadcast with the lobby's vector clock at that point
+func (s *server) lastLobby() (uint64, clock.Vector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.seq, s.lobby.Copy()
+}
+
+func (s *server) subscriberCount() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.subscribers)
+}
+
+// broadcast commits msg and sends it to every client
+func (s *server) broadcast(msg *pb.GenericText) error {
+	return s.commit(&pb.Record{Op: &pb.Record_Broadcast{Broadcast: msg}})
+}
+
+// deliver gives msg the next sequence number and pushes it to every subscriber,
+// the sender included, so everyone sees the same transcript
+func (s *server) deliver(msg *pb.GenericText) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.seq++
+	msg.Seq = s.seq
+	if msg.Room == "" && msg.Recipient == nil {
+		switch msg.Kind {
+		case pb.EventKind_MESSAGE:
+			s.lobby.Merge(msg.Vector)
+		case pb.EventKind_JOIN:
+			//Tells causal clients where the one joining counts on from, its last session may be gone
+			id := msg.Client.GetId()
+			msg.Vector = map[int64]uint64{id: s.lobby[id]}
+		}
+	}
+	s.retransmit.add(msg)
+	s.history.add(msg)
+
+	for id, ch := range s.subscribers {
+		if !s.visible(msg, id) {
+			continue
+		}
+		//Every subscriber gets its own copy, telling it which broadcast it saw last
+		out := proto.Clone(msg).(*pb.GenericText)
+		out.Prev = s.seen[id]
+		s.seen[id] = msg.Seq
+		select {
+		case ch <- out:
+		default:
+			log.Println(s.name(id) + ": is too slow, dropping broadcast " + strconv.FormatUint(msg.Seq, 10))
+		}
+	}
+}
+
+func (s *server) Fetch(ctx context.Context, in *pb.FetchRequest) (*pb.FetchReply, error) {
+	if in.FromSeq == 0 || in.FromSeq > in.ToSeq {
+		return nil, routeError(codes.InvalidArgument, pb.ErrorReason_INVALID_RANGE, "from_seq must be between 1 and to_seq")
+	}
+	if in.ToSeq-in.FromSeq >= uint64(cfg.MaxFetch) {
+		in.ToSeq = in.FromSeq + uint64(cfg.MaxFetch) - 1
+	}
+	log.Println(s.name(in.Client.GetId()) + ": fetching " + strconv.FormatUint(in.FromSeq, 10) + "-" + strconv.FormatUint(in.ToSeq, 10))
+
+	//Only resend what the client was allowed to see, messages in other rooms are not missing
+	var visible []*pb.GenericText
+	for _, msg := range s.retransmit.get(in.FromSeq, in.ToSeq) {
+		if s.visible(msg, in.Client.GetId()) {
+			visible = append(visible, msg)
+		}
+	}
+	return &pb.FetchReply{Messages: visible, ToSeq: in.ToSeq}, nil
 }
 
 func (s *server) SayHello(ctx context.Context, inText *pb.RequestText) (*pb.ReplyText, error) {
 
-	//Show text from client
-	log.Println("Client " + strconv.FormatInt(inText.Client.GetId(), 10) + ": " + inText.GetBody())
+	//Show text from client and pass it on to everyone else
+	msg, err := s.publish(inText)
+	if err != nil {
+		return nil, err
+	}
+
+	//Tell client that their message was recived. The reply is made from the broadcast
+	//alone, so a retry of the message gets the same one
+	return &pb.ReplyText{Body: msg.Body + " from server", Lamport: msg.Lamport}, nil
 
-	//Tell client that their message was recived
-	return &pb.ReplyText{Body: inText.Body + " from server"}, nil
+}
+
+// publish logs a client's text and broadcasts it to all clients.
+// SayHello, BroadcastMessage and chat sessions all end up here.
+func (s *server) publish(in *pb.RequestText) (*pb.GenericText, error) {
+	s.clock.Witness(in.GetLamport())
+	//A retry gets what the message broadcast the first time, even if the client has moved on since
+	if in.RequestId != nil {
+		if in.RequestId.Client != in.Client.GetId() {
+			return nil, routeError(codes.InvalidArgument, pb.ErrorReason_IDENTITY_MISMATCH, "the request id belongs to another client")
+		}
+		if msg, ok := s.answered.get(in.RequestId); ok {
+			log.Println(s.name(in.Client.GetId()) + ": already sent request " + strconv.FormatUint(in.RequestId.Seq, 10) + ", answering with #" + strconv.FormatUint(msg.Seq, 10))
+			return msg, nil
+		}
+	}
+	if len(in.Body) > cfg.MaxBody {
+		return nil, routeError(codes.ResourceExhausted, pb.ErrorReason_MESSAGE_TOO_LARGE, "messages are limited to "+strconv.Itoa(cfg.MaxBody)+" bytes")
+	}
+	if in.Recipient != nil {
+		if err := s.checkRecipient(in); err != nil {
+			return nil, err
+		}
+		//Direct messages don't belong to a room
+		in.Room = ""
+	} else if !s.rooms.has(in.Room, in.Client.GetId()) {
+		return nil, errNotAMember(in.Room)
+	}
+
+	//Stamp the sender's registered profile, whatever the client claims its name is
+	in.Client = s.profile(in.Client)
+	if in.Recipient != nil {
+		in.Recipient = s.profile(in.Recipient)
+	}
+
+	msg := &pb.GenericText{Body: in.Body, Client: in.Client, Kind: pb.EventKind_MESSAGE, Vector: in.Vector, Room: in.Room, Recipient: in.Recipient, RequestId: in.RequestId}
+	err := s.commit(&pb.Record{Op: &pb.Record_Broadcast{Broadcast: msg}, Request: in.RequestId})
+	if errors.Is(err, errAnswered) {
+		//The first copy got in while this one was on its way
+		if first, ok := s.answered.get(in.RequestId); ok {
+			return first, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prefix := "[" + stamp(msg.Lamport) + "] #" + strconv.FormatUint(msg.Seq, 10) + " "
+	from := in.Client.Name
+	switch {
+	case in.Recipient != nil:
+		log.Println(prefix + "DM " + from + " -> " + in.Recipient.Name + ": " + in.GetBody())
+	case in.Room != "":
+		log.Println(prefix + from + " in #" + in.Room + ": " + in.GetBody())
+	default:
+		log.Println(prefix + from + ": " + in.GetBody())
+	}
+	return msg, nil
+}
 
+func stamp(lamport uint64) string {
+	return strconv.FormatUint(lamport, 10)
 }
 
 func (s *server) Connect(ctx context.Context, in *pb.ConnectRequest) (*pb.Acknowledgement, error) {
+	if s.isStopping() {
+		return nil, errShuttingDown()
+	}
+	//Clients connect to the leader, the only server that keeps leases
+	if err := s.checkLeader(); err != nil {
+		return nil, err
+	}
+	//A client that lost its connection comes back with the token it had
+	if in.Token != "" {
+		if ack, ok := s.resume(ctx, in); ok {
+			return ack, nil
+		}
+	}
+
+	in.Name = strings.TrimSpace(in.Name)
+	//Clients with a certificate are who it says they are
+	if cn := certName(ctx); cn != "" {
+		if in.Name != "" && !strings.EqualFold(in.Name, cn) {
+			return nil, errCertMismatch(cn)
+		}
+		in.Name = cn
+	}
+	if s.auth != nil {
+		if err := s.auth.Authenticate(in.User, in.Password); err != nil {
+			log.Println("Failed login as " + strconv.Quote(in.User) + " from " + address(ctx) + ": " + err.Error())
+			return nil, routeError(codes.PermissionDenied, pb.ErrorReason_AUTHENTICATION_FAILED, "wrong user name, password or key")
+		}
+		//A user who proved who they are chats as themselves, like clients with a certificate
+		if s.auth.Identifies() {
+			if in.Name != "" && !strings.EqualFold(in.Name, in.User) {
+				return nil, errUserMismatch(in.User)
+			}
+			in.Name = in.User
+		}
+	}
+	//The password has done its job, keep it out of the log
+	in.Password = ""
+	if in.Name == "" {
+		in.Name = in.User
+	}
+	if in.Name != "" {
+		if err := checkName(in.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := s.replaceStale(in); err != nil {
+		return nil, err
+	}
+
+	//Add client to servers list of clients when connecting, commit refuses duplicates,
+	//hands out an id and a name when the client didn't pick them and mints the session token
+	in.Token = ""
+	if err := s.commit(&pb.Record{Op: &pb.Record_Connect{Connect: in}}); err != nil {
+		return nil, err
+	}
 
 	//Show that a new client has connected on server
-	log.Println("Client " + strconv.FormatInt(in.Id, 10) + ": has connected")
+	profile := s.profile(&pb.Client{Id: in.Id})
+	log.Println(profile.Name + ": has connected")
+	log.Println(s.clients.ids())
 
-	//Add client to servers list of clients when connecting
+	//Answer client
+	seq, vector := s.lastLobby()
+	return &pb.Acknowledgement{Status: "Successfully connected as " + profile.Name, LeaseTtlMs: s.leases.ttl.Milliseconds(), Client: profile, Token: in.Token, LastSeq: seq, Vector: vector}, nil
+}
 
-	for _, client := range s.connectedClients {
-		if client == strconv.FormatInt(in.Id, 10) {
-			return nil, &argError{"Name doublication", "Name already exists on server"}
+// resume picks up the session the token belongs to, if it is still alive and belongs to
+// the client asking. Nothing is committed, the client never left as far as the server knows.
+func (s *server) resume(ctx context.Context, in *pb.ConnectRequest) (*pb.Acknowledgement, bool) {
+	id, ok := s.sessions.resolve(in.Token)
+	if !ok || id != in.Id || !s.leases.renew(id) {
+		return nil, false
+	}
+	profile := s.profile(&pb.Client{Id: id})
+	if cn := certName(ctx); cn != "" && !strings.EqualFold(cn, profile.Name) {
+		return nil, false
+	}
+
+	log.Println(profile.Name + ": has resumed its session")
+	seq, vector := s.lastLobby()
+	return &pb.Acknowledgement{Status: "Resumed session as " + profile.Name, LeaseTtlMs: s.leases.ttl.Milliseconds(), Client: profile, Token: in.Token, LastSeq: seq, Vector: vector}, true
+}
+
+// replaceStale evicts the client registered under the id asked for when its stream
+// ended and it never came back for the session, so a client that restarted with its
+// -id gets it back instead of waiting for the lease to run out. Only a client with the
+// same name may take it back, the name it was given when it didn't pick one included.
+// Anyone else is refused as a duplicate by commit.
+func (s *server) replaceStale(in *pb.ConnectRequest) error {
+	if in.Id == 0 || !s.streamDropped(in.Id) {
+		return nil
+	}
+	stale, ok := s.clients.get(in.Id)
+	if !ok {
+		return nil
+	}
+	name := in.Name
+	if name == "" {
+		name = defaultName(in.Id)
+	}
+	if !strings.EqualFold(stale.Name, name) {
+		return nil
+	}
+	log.Println(stale.Name + ": connected again, dropping its old session")
+	return s.leave(stale, false)
+}
+
+func (s *server) Heartbeat(ctx context.Context, in *pb.Client) (*pb.Acknowledgement, error) {
+	if err := s.checkLeader(); err != nil {
+		return nil, err
+	}
+	if !s.leases.renew(in.GetId()) {
+		return nil, errUnknownClient()
+	}
+	return &pb.Acknowledgement{Status: "Lease renewed", LeaseTtlMs: s.leases.ttl.Milliseconds()}, nil
+}
+
+// reap evicts clients with lapsed leases every interval, forever. In a cluster only the leader does.
+func (s *server) reap(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	leading := false
+	for range ticker.C {
+		if s.leader != nil {
+			wasLeading := leading
+			leading = s.leader.IsLeader()
+			if !leading {
+				continue
+			}
+			//Clients renewed their leases with the old leader, give them all a fresh one
+			if !wasLeading {
+				for id := range s.clients.snapshot() {
+					s.leases.grant(id)
+				}
+			}
 		}
+		s.reapOnce()
 	}
+}
 
-	s.connectedClients = append(s.connectedClients, strconv.FormatInt(in.Id, 10))
-	log.Println(s.connectedClients)
+func (s *server) reapOnce() {
+	for _, id := range s.leases.expired() {
+		log.Println(s.name(id) + ": lease expired")
+		s.kick(id)
+		if err := s.leave(&pb.Client{Id: id}, false); err != nil {
+			log.Printf("could not evict client %d: %v", id, err)
+		}
+	}
+}
 
-	//Answer client
-	return &pb.Acknowledgement{Status: "Successfully connected"}, nil
+func (s *server) Disconnect(ctx context.Context, in *pb.Client) (*pb.Acknowledgement, error) {
+	if err := s.leave(in, true); err != nil {
+		return nil, err
+	}
+	return &pb.Acknowledgement{Status: "Successfully disconnected"}, nil
+}
+
+// leave removes the client from the server and tells everyone else it left.
+// goodbye is set when the client asked to go rather than being evicted.
+func (s *server) leave(client *pb.Client, goodbye bool) error {
+	//Look the profile up first, it is gone once the client is
+	profile := s.profile(client)
+	if err := s.commit(&pb.Record{Op: &pb.Record_Disconnect{Disconnect: client}, Goodbye: goodbye}); err != nil {
+		return err
+	}
+	log.Println(profile.Name + ": has disconnected")
+	log.Println(s.clients.ids())
+
+	return s.broadcast(&pb.GenericText{Body: profile.Name + " left", Client: profile, Kind: pb.EventKind_LEAVE})
+}
+
+func (s *server) isConnected(id int64) bool {
+	return s.clients.has(id)
+}
+
+// newServer makes a server with nobody connected, set up as cfg says. Leases
+// read the time from now.
+func newServer(now func() time.Time) *server {
+	return &server{
+		clients:     newRegistry(),
+		sessions:    newSessions(),
+		subscribers: make(map[int64]chan *pb.GenericText),
+		seen:        make(map[int64]uint64),
+		dropped:     make(map[int64]bool),
+		lobby:       make(clock.Vector),
+		leases:      newLeases(cfg.LeaseTTL, now),
+		retransmit:  newRetransmitBuffer(cfg.RetransmitSize),
+		answered:    newAnsweredRequests(cfg.DedupTTL),
+		rooms:       newRooms(),
+	}
 }
 
 func main() {
-	//Make connected client slice
-	server := server{
-		connectedClients: make([]string, 0),
+	loaded, printOnly, err := loadConfig(os.Args[1:])
+	if err != nil {
+		log.Fatalf("%v", err)
+	}
+	cfg = loaded
+	if printOnly {
+		out, err := cfg.print()
+		if err != nil {
+			log.Fatalf("could not print config: %v", err)
+		}
+		fmt.Println(string(out))
+		return
+	}
+	if cfg.LogFile != "" {
+		f, err := os.OpenFile(cfg.LogFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			log.Fatalf("failed to open log file: %v", err)
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+
+	//Make connected client registry
+	server := newServer(time.Now)
+
+	auth, err := newAuthenticator(cfg.UsersFile, cfg.AuthKeyFile)
+	if err != nil {
+		log.Fatalf("failed to load credentials: %v", err)
 	}
+	server.auth = auth
 
 	//Start server
-	lis, err := net.Listen("tcp", ":5000")
+	lis, err := net.Listen("tcp", cfg.Listen)
 	if err != nil {
 		log.Fatalf("failed to listen: %v", err)
 	}
-	s := grpc.NewServer()
-	pb.RegisterRouteServer(s, &server)
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(server.authUnary), grpc.StreamInterceptor(server.authStream)}
+	//The servers of a cluster dial each other with the server certificate
+	dial := grpc.WithInsecure()
+	if cfg.TLSCert != "" {
+		config, err := certs.ServerConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSCA, cfg.RequireClientCert)
+		if err != nil {
+			log.Fatalf("failed to load certificates: %v", err)
+		}
+		opts = append(opts, grpc.Creds(credentials.NewTLS(config)))
+		peerConfig, err := certs.ClientConfig(cfg.TLSCert, cfg.TLSKey, cfg.TLSCA)
+		if err != nil {
+			log.Fatalf("failed to load certificates: %v", err)
+		}
+		dial = grpc.WithTransportCredentials(credentials.NewTLS(peerConfig))
+	}
+	s := grpc.NewServer(opts...)
+	pb.RegisterRouteServer(s, server)
+
+	//Bring back everything from before the last restart, from the cluster or the log
+	stopRaft := func() {}
+	if cfg.replicated() {
+		stopRaft, err = server.startRaft(s, dial)
+		if err != nil {
+			log.Fatalf("failed to start raft: %v", err)
+		}
+	} else if cfg.DataDir != "" {
+		store, err := wal.Open(cfg.DataDir, cfg.SegmentSize)
+		if err != nil {
+			log.Fatalf("failed to open log: %v", err)
+		}
+		defer store.Close()
+		server.wal = store
+		if err := server.recover(); err != nil {
+			log.Fatalf("failed to recover from log: %v", err)
+		}
+	}
+	stopElection := func() {}
+	if cfg.Election != "" {
+		stopElection, err = server.startElection(s, dial)
+		if err != nil {
+			log.Fatalf("failed to start election: %v", err)
+		}
+	}
+	if server.leader != nil {
+		go server.watchLeadership(leadershipCheck)
+	}
+	go server.reap(cfg.ReapInterval)
+	//Drain on Ctrl-C or kill
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	stopped := make(chan struct{})
+	go func() {
+		sig := <-sigs
+		log.Println("Received " + sig.String() + ", shutting down")
+		server.shutdown(s, cfg.ShutdownTimeout)
+		close(stopped)
+	}()
+
 	log.Printf("server listening at %v", lis.Addr())
 	if err := s.Serve(lis); err != nil {
 		log.Fatalf("failed to serve: %v", err)
 	}
+
+	//Serve returns as soon as shutdown starts, the log stays open until the last call is done
+	<-stopped
+	stopElection()
+	stopRaft()
+	if server.wal != nil {
+		if err := server.wal.Close(); err != nil {
+			log.Printf("could not flush log: %v", err)
+		}
+	}
+	log.Println("Server stopped")
 }
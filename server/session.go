@@ -0,0 +1,142 @@
+package main
+
+import (
+	"google.golang.org/grpc/codes"
+	"io"
+	"log"
+	pb "program/route"
+	"strconv"
+)
+
+// session is one client's long-lived Chat stream. Everything the server wants
+// the client to see, its own notices included, goes down the same stream.
+type session struct {
+	srv    *server
+	client *pb.Client
+	stream pb.Route_ChatServer
+	out    chan *pb.GenericText
+}
+
+func (s *server) Chat(stream pb.Route_ChatServer) error {
+	//The first message identifies the client
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	hello := first.GetSend()
+	if hello.GetClient() == nil {
+		return routeError(codes.InvalidArgument, pb.ErrorReason_MISSING_CLIENT, "first chat message must identify the client")
+	}
+
+	s.clock.Witness(hello.GetLamport())
+	sess := &session{
+		srv:    s,
+		client: hello.Client,
+		stream: stream,
+		out:    s.subscribe(hello.Client.GetId()),
+	}
+	return sess.run()
+}
+
+// rejection is text sent on the stream that the server didn't take, and why
+type rejection struct {
+	request *pb.RequestId
+	err     error
+}
+
+func (sess *session) run() error {
+	profile := sess.srv.profile(sess.client)
+	log.Println(profile.Name + ": has joined the chat")
+	defer sess.close()
+	defer func() {
+		//The client may have disconnected, and been forgotten, before its stream ended
+		if current, ok := sess.srv.clients.get(sess.client.GetId()); ok {
+			profile = current
+		}
+		log.Println(profile.Name + ": has left the chat")
+	}()
+
+	if err := sess.srv.broadcast(&pb.GenericText{Body: profile.Name + " joined", Client: profile, Kind: pb.EventKind_JOIN}); err != nil {
+		return err
+	}
+
+	if err := sess.notice("Welcome, " + strconv.Itoa(sess.srv.subscriberCount()) + " clients online"); err != nil {
+		return err
+	}
+
+	//Read incoming text on its own goroutine so the server can speak at any time.
+	//Only this goroutine may send on the stream, so rejected text comes back through rejected
+	recvErr := make(chan error, 1)
+	rejected := make(chan rejection, cfg.SubscriberBuffer)
+	go func() {
+		for {
+			in, err := sess.stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			if text := in.GetSend(); text != nil {
+				text.Client = sess.client
+				if _, err := sess.srv.publish(text); err != nil {
+					rejected <- rejection{request: text.RequestId, err: err}
+				}
+			}
+		}
+	}()
+
+	for {
+		select {
+		case msg, ok := <-sess.out:
+			//The server kicked us out
+			if !ok {
+				if sess.srv.isStopping() {
+					return errShuttingDown()
+				}
+				if err := sess.srv.checkLeader(); err != nil {
+					return err
+				}
+				return routeError(codes.FailedPrecondition, pb.ErrorReason_LEASE_EXPIRED, "client missed its heartbeats")
+			}
+			if err := sess.send(msg); err != nil {
+				return err
+			}
+		case r := <-rejected:
+			//Text can only go through the leader. Ending the stream sends the client there,
+			//with this message and whatever it sent after it
+			if isNotLeader(r.err) {
+				leader := ""
+				if sess.srv.leader != nil {
+					leader = sess.srv.leader.Leader()
+				}
+				return errNotSent(leader, r.request)
+			}
+			if err := sess.notice("Message not sent: " + r.err.Error()); err != nil {
+				return err
+			}
+		case err := <-recvErr:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-sess.stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// close runs when the stream ends. A client whose stream dropped without a
+// Disconnect stays registered, with its rooms and token, so it can resume the
+// session. It is evicted when its lease runs out, or when it connects again
+// without the token, as it does after a restart.
+func (sess *session) close() {
+	sess.srv.unsubscribe(sess.client.GetId(), sess.out)
+}
+
+func (sess *session) send(msg *pb.GenericText) error {
+	return sess.stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Event{Event: msg}})
+}
+
+// notice sends a server message to this session only.
+func (sess *session) notice(body string) error {
+	return sess.send(&pb.GenericText{Body: body, Kind: pb.EventKind_NOTICE, Lamport: sess.srv.clock.Tick()})
+}
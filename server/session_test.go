@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/grpc/metadata"
+	pb "program/route"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDroppedStreamKeepsTheSession(t *testing.T) {
+	s := newServer(time.Now)
+	client := serveRoute(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ack, err := client.Connect(ctx, &pb.ConnectRequest{Name: "alice"})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	authed := metadata.AppendToOutgoingContext(ctx, tokenKey, ack.Token)
+	if _, err := client.JoinRoom(authed, &pb.RoomRequest{Client: ack.Client, Room: "dev"}); err != nil {
+		t.Fatalf("join: %v", err)
+	}
+
+	//Open a chat stream, wait for the welcome and drop it without saying goodbye
+	streamCtx, drop := context.WithCancel(authed)
+	stream, err := client.Chat(streamCtx)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if err := stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Send{Send: &pb.RequestText{Client: ack.Client}}}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	drop()
+	for deadline := time.Now().Add(5 * time.Second); s.subscriberCount() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the server never noticed the stream dropped")
+		}
+	}
+
+	if !s.isConnected(ack.Client.Id) || !s.rooms.has("dev", ack.Client.Id) {
+		t.Fatal("the client was forgotten when its stream dropped")
+	}
+	resumed, err := client.Connect(ctx, &pb.ConnectRequest{Id: ack.Client.Id, Name: "alice", Token: ack.Token})
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	if resumed.Token != ack.Token {
+		t.Fatal("the session was not resumed")
+	}
+}
+
+func TestRestartedClientTakesItsIDBack(t *testing.T) {
+	s := newServer(time.Now)
+	client := serveRoute(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ack, err := client.Connect(ctx, &pb.ConnectRequest{Id: 5, Name: "alice"})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	streamCtx, drop := context.WithCancel(metadata.AppendToOutgoingContext(ctx, tokenKey, ack.Token))
+	stream, err := client.Chat(streamCtx)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if err := stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Send{Send: &pb.RequestText{Client: ack.Client}}}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+
+	//While its stream is open the id is in use
+	if _, err := client.Connect(ctx, &pb.ConnectRequest{Id: 5, Name: "alice"}); reasonOf(err) != pb.ErrorReason_DUPLICATE_CLIENT.String() {
+		t.Fatalf("connect while the stream is open: %v", err)
+	}
+
+	//The process dies without saying goodbye and starts again, with no token
+	drop()
+	for deadline := time.Now().Add(5 * time.Second); s.subscriberCount() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the server never noticed the stream dropped")
+		}
+	}
+	again, err := client.Connect(ctx, &pb.ConnectRequest{Id: 5, Name: "alice"})
+	if err != nil {
+		t.Fatalf("connect after a restart: %v", err)
+	}
+	if again.Client.Id != 5 || again.Token == ack.Token {
+		t.Fatalf("got id %d, token reused %v", again.Client.Id, again.Token == ack.Token)
+	}
+	if _, ok := s.sessions.resolve(ack.Token); ok {
+		t.Fatal("the old session's token still works")
+	}
+}
+
+func TestOtherClientCannotTakeADroppedID(t *testing.T) {
+	s := newServer(time.Now)
+	client := serveRoute(t, s)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ack, err := client.Connect(ctx, &pb.ConnectRequest{Id: 5})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	streamCtx, drop := context.WithCancel(metadata.AppendToOutgoingContext(ctx, tokenKey, ack.Token))
+	stream, err := client.Chat(streamCtx)
+	if err != nil {
+		t.Fatalf("chat: %v", err)
+	}
+	if err := stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Send{Send: &pb.RequestText{Client: ack.Client}}}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	if _, err := stream.Recv(); err != nil {
+		t.Fatalf("recv: %v", err)
+	}
+	drop()
+	for deadline := time.Now().Add(5 * time.Second); s.subscriberCount() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("the server never noticed the stream dropped")
+		}
+	}
+
+	//Someone else asking for the id is still a duplicate
+	if _, err := client.Connect(ctx, &pb.ConnectRequest{Id: 5, Name: "mallory"}); reasonOf(err) != pb.ErrorReason_DUPLICATE_CLIENT.String() {
+		t.Fatalf("connect as mallory: %v", err)
+	}
+	if _, ok := s.sessions.resolve(ack.Token); !ok {
+		t.Fatal("the dropped client lost its session")
+	}
+
+	//It was never named, so it comes back under the name it was given
+	if _, err := client.Connect(ctx, &pb.ConnectRequest{Id: 5}); err != nil {
+		t.Fatalf("connect after a restart: %v", err)
+	}
+}
+
+func TestLateJoinerGetsTheLobbyClock(t *testing.T) {
+	s := newServer(time.Now)
+	alice, stream := chatOnLeader(t, s)
+	text := &pb.RequestText{Body: "hello", Client: alice, Vector: map[int64]uint64{alice.Id: 1}}
+	if err := stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Send{Send: text}}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	for {
+		in, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		if in.GetEvent().GetKind() == pb.EventKind_MESSAGE {
+			break
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	ack, err := serveRoute(t, s).Connect(ctx, &pb.ConnectRequest{Name: "bob"})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if want := map[int64]uint64{alice.Id: 1}; !reflect.DeepEqual(ack.Vector, want) {
+		t.Fatalf("ack vector = %v, want %v", ack.Vector, want)
+	}
+}
+
+func TestSenderSeesWhichRequestArrived(t *testing.T) {
+	s := newServer(time.Now)
+	alice, stream := chatOnLeader(t, s)
+	text := &pb.RequestText{Body: "hello", Client: alice, RequestId: &pb.RequestId{Client: alice.Id, Seq: 7}}
+	if err := stream.Send(&pb.ChatMessage{Payload: &pb.ChatMessage_Send{Send: text}}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+	for {
+		in, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("recv: %v", err)
+		}
+		if msg := in.GetEvent(); msg.GetKind() == pb.EventKind_MESSAGE {
+			if msg.RequestId.GetSeq() != 7 {
+				t.Fatalf("broadcast carries request %v, want 7", msg.RequestId)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"log"
+	pb "program/route"
+	"time"
+)
+
+func (s *server) isStopping() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.stopping
+}
+
+func errShuttingDown() error {
+	return routeError(codes.Unavailable, pb.ErrorReason_SHUTTING_DOWN, "the server is shutting down")
+}
+
+// shutdown stops taking clients, says goodbye on every open stream and ends it,
+// then gives the calls still running until timeout to finish before cutting them off.
+// Clients stay registered, so they are still known if the server comes back from its log.
+func (s *server) shutdown(g *grpc.Server, timeout time.Duration) {
+	s.mu.Lock()
+	s.stopping = true
+	notice := &pb.GenericText{Body: "Server is shutting down", Kind: pb.EventKind_NOTICE, Lamport: s.clock.Tick()}
+	for id, ch := range s.subscribers {
+		select {
+		case ch <- notice:
+		default:
+		}
+		delete(s.subscribers, id)
+		close(ch)
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		g.GracefulStop()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		log.Println("Calls still running after " + timeout.String() + ", stopping anyway")
+		g.Stop()
+		<-done
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	pb "program/route"
+	"sync"
+	"testing"
+	"time"
+)
+
+// holdingServer serves s and parks every SayHello call before it runs until release is closed
+type holdingServer struct {
+	s       *server
+	g       *grpc.Server
+	addr    string
+	arrived sync.WaitGroup
+	release chan struct{}
+}
+
+func startHoldingServer(t *testing.T, calls int) *holdingServer {
+	t.Helper()
+	h := &holdingServer{s: newServer(time.Now), release: make(chan struct{})}
+	h.arrived.Add(calls)
+	hold := func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if info.FullMethod == "/Route/SayHello" {
+			h.arrived.Done()
+			<-h.release
+		}
+		return handler(ctx, req)
+	}
+	h.g = routeServer(h.s, grpc.ChainUnaryInterceptor(hold))
+	h.addr = serve(t, h.g)
+	return h
+}
+
+// sayHellos connects a client and sends calls SayHellos from it at once. The
+// results come back on the channel once the calls return.
+func (h *holdingServer) sayHellos(t *testing.T, calls int) <-chan error {
+	t.Helper()
+	client := pb.NewRouteClient(dialRoute(t, h.addr, grpc.WithInsecure()))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+	ack, err := client.Connect(ctx, &pb.ConnectRequest{Name: "alice"})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, tokenKey, ack.Token)
+
+	results := make(chan error, calls)
+	for i := 0; i < calls; i++ {
+		go func(i int) {
+			body := fmt.Sprintf("hello %d", i)
+			reply, err := client.SayHello(ctx, &pb.RequestText{Body: body, Client: ack.Client})
+			if err == nil && reply.Body != body+" from server" {
+				err = fmt.Errorf("reply %q to %q", reply.Body, body)
+			}
+			results <- err
+		}(i)
+	}
+	return results
+}
+
+func TestShutdownLetsCallsFinish(t *testing.T) {
+	const calls = 5
+	h := startHoldingServer(t, calls)
+	results := h.sayHellos(t, calls)
+	h.arrived.Wait()
+
+	stopped := make(chan struct{})
+	go func() {
+		h.s.shutdown(h.g, 10*time.Second)
+		close(stopped)
+	}()
+	//Shutdown waits for the calls in flight
+	select {
+	case <-stopped:
+		t.Fatal("shutdown returned with calls still running")
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := h.s.Connect(context.Background(), &pb.ConnectRequest{Name: "bob"}); reasonOf(err) != pb.ErrorReason_SHUTTING_DOWN.String() {
+		t.Errorf("connect during shutdown: %v", err)
+	}
+
+	close(h.release)
+	for i := 0; i < calls; i++ {
+		if err := <-results; err != nil {
+			t.Errorf("call in flight during shutdown: %v", err)
+		}
+	}
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown didn't return once the calls were done")
+	}
+}
+
+func TestShutdownCutsOffCallsAfterTimeout(t *testing.T) {
+	h := startHoldingServer(t, 1)
+	defer close(h.release)
+	results := h.sayHellos(t, 1)
+	h.arrived.Wait()
+
+	start := time.Now()
+	h.s.shutdown(h.g, 200*time.Millisecond)
+	if took := time.Since(start); took > 5*time.Second {
+		t.Fatalf("shutdown took %v with a timeout of 200ms", took)
+	}
+	select {
+	case err := <-results:
+		if err == nil {
+			t.Fatal("a call still held back succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call that was cut off never returned")
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+	"log"
+	pb "program/route"
+	"strconv"
+	"time"
+)
+
+// commit checks a state change against the current state, makes it durable in
+// the write-ahead log, when there is one, or replicates it to the cluster, and
+// then applies it. Commits run one at a time, so nothing can change between the
+// check and the apply. Callers only acknowledge clients after commit returns.
+func (s *server) commit(rec *pb.Record) error {
+	s.commitMu.Lock()
+	defer s.commitMu.Unlock()
+	if s.raft != nil {
+		return s.replicate(rec)
+	}
+
+	//Without Raft, servers that lost an election have none of the state and take no changes
+	if err := s.checkLeader(); err != nil {
+		return err
+	}
+
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
+	if err := s.prepare(rec); err != nil {
+		return err
+	}
+	if s.wal != nil {
+		data, err := proto.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		if err := s.wal.Append(data); err != nil {
+			log.Printf("could not write to log: %v", err)
+			return routeError(codes.Internal, pb.ErrorReason_STORAGE_FAILURE, "could not persist the change")
+		}
+	}
+	s.apply(rec)
+	return nil
+}
+
+// prepare fills in what the server decides for the client and checks the change. Call with applyMu held.
+func (s *server) prepare(rec *pb.Record) error {
+	//Clients that didn't pick an id or a name get the next free id and a name made from it.
+	//Every connect gets a new session token
+	if c := rec.GetConnect(); c != nil {
+		if c.Id == 0 {
+			c.Id = s.lastID + 1
+		}
+		if c.Name == "" {
+			c.Name = defaultName(c.Id)
+		}
+		token, err := newToken()
+		if err != nil {
+			return err
+		}
+		c.Token = token
+	}
+
+	if err := s.validate(rec); err != nil {
+		return err
+	}
+
+	//Broadcasts get their timestamp here so the log and the clients agree on it
+	if msg := rec.GetBroadcast(); msg != nil {
+		msg.Lamport = s.clock.Tick()
+	}
+	if rec.Request != nil {
+		rec.Time = time.Now().UnixMilli()
+	}
+	return nil
+}
+
+// validate refuses changes that don't fit the current state
+func (s *server) validate(rec *pb.Record) error {
+	if rec.Request != nil {
+		if _, ok := s.answered.get(rec.Request); ok {
+			return errAnswered
+		}
+	}
+	switch op := rec.Op.(type) {
+	case *pb.Record_Connect:
+		if s.clients.has(op.Connect.Id) {
+			return routeError(codes.AlreadyExists, pb.ErrorReason_DUPLICATE_CLIENT, "client "+strconv.FormatInt(op.Connect.Id, 10)+" is already connected")
+		}
+		if _, taken := s.clients.owner(op.Connect.Name); taken {
+			return errNameTaken(op.Connect.Name)
+		}
+	case *pb.Record_Rename:
+		if !s.clients.has(op.Rename.Client.GetId()) {
+			return errUnknownClient()
+		}
+		if owner, taken := s.clients.owner(op.Rename.Name); taken && owner != op.Rename.Client.GetId() {
+			return errNameTaken(op.Rename.Name)
+		}
+	case *pb.Record_Disconnect:
+		if !s.clients.has(op.Disconnect.GetId()) {
+			return errUnknownClient()
+		}
+	case *pb.Record_JoinRoom:
+		if !s.clients.has(op.JoinRoom.Client.GetId()) {
+			return errUnknownClient()
+		}
+	case *pb.Record_LeaveRoom:
+		if !s.rooms.has(op.LeaveRoom.Room, op.LeaveRoom.Client.GetId()) {
+			return errNotAMember(op.LeaveRoom.Room)
+		}
+	}
+	return nil
+}
+
+// apply changes the server's state. It is used both for new changes and when
+// replaying the log at startup, so it must give the same result both times.
+func (s *server) apply(rec *pb.Record) {
+	switch op := rec.Op.(type) {
+	case *pb.Record_Connect:
+		s.clients.add(&pb.Client{Id: op.Connect.Id, Name: op.Connect.Name, Status: op.Connect.Status})
+		s.leases.grant(op.Connect.Id)
+		s.sessions.grant(op.Connect.Token, op.Connect.Id)
+		if op.Connect.Id > s.lastID {
+			s.lastID = op.Connect.Id
+		}
+	case *pb.Record_Disconnect:
+		s.forget(op.Disconnect.GetId())
+		//An evicted client may come back and retry what it sent, the table's ttl takes care of those
+		if rec.Goodbye {
+			s.answered.forget(op.Disconnect.GetId())
+		}
+	case *pb.Record_Rename:
+		s.clients.rename(op.Rename.Client.GetId(), op.Rename.Name)
+	case *pb.Record_JoinRoom:
+		s.rooms.join(op.JoinRoom.Room, op.JoinRoom.Client.GetId())
+	case *pb.Record_LeaveRoom:
+		s.rooms.leave(op.LeaveRoom.Room, op.LeaveRoom.Client.GetId())
+	case *pb.Record_Broadcast:
+		s.clock.Advance(op.Broadcast.Lamport)
+		s.deliver(op.Broadcast)
+		if rec.Request != nil {
+			s.answered.add(&pb.Answered{Id: rec.Request, Time: rec.Time, Broadcast: op.Broadcast})
+		}
+	}
+}
+
+// forget drops the client from everything that knows it
+func (s *server) forget(id int64) {
+	s.clients.remove(id)
+	s.leases.revoke(id)
+	s.sessions.revoke(id)
+	s.rooms.leaveAll(id)
+	s.mu.Lock()
+	delete(s.dropped, id)
+	s.mu.Unlock()
+}
+
+// recover rebuilds the server's state from the write-ahead log
+func (s *server) recover() error {
+	count := 0
+	err := s.wal.Replay(func(data []byte) error {
+		rec := &pb.Record{}
+		if err := proto.Unmarshal(data, rec); err != nil {
+			return err
+		}
+		s.apply(rec)
+		count++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	log.Println("Recovered " + strconv.Itoa(count) + " records, last broadcast #" + strconv.FormatUint(s.seq, 10))
+	log.Println(s.clients.ids())
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	pb "program/route"
+	"sort"
+	"sync"
+)
+
+// messageStore keeps every chat message the server has delivered, in sequence
+// order, for the History RPC. It is rebuilt from the write-ahead log or the Raft log on startup.
+type messageStore struct {
+	mu   sync.Mutex
+	msgs []*pb.GenericText
+}
+
+// add stores msg. Messages must be added in sequence order.
+func (m *messageStore) add(msg *pb.GenericText) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msgs = append(m.msgs, msg)
+}
+
+// all returns every stored message, oldest first
+func (m *messageStore) all() []*pb.GenericText {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]*pb.GenericText(nil), m.msgs...)
+}
+
+// replace swaps the stored messages for msgs, which must be in sequence order
+func (m *messageStore) replace(msgs []*pb.GenericText) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.msgs = msgs
+}
+
+// page returns up to n of the newest messages with a sequence number below
+// before (0 means no limit) for which keep is true, oldest first. more reports
+// whether there are older matching messages left.
+func (m *messageStore) page(before uint64, n int, keep func(*pb.GenericText) bool) (found []*pb.GenericText, more bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	end := len(m.msgs)
+	if before != 0 {
+		end = sort.Search(len(m.msgs), func(i int) bool { return m.msgs[i].Seq >= before })
+	}
+	for i := end - 1; i >= 0; i-- {
+		if !keep(m.msgs[i]) {
+			continue
+		}
+		if len(found) == n {
+			more = true
+			break
+		}
+		found = append(found, m.msgs[i])
+	}
+
+	//Collected newest first, hand them back in the order they were sent
+	for i, j := 0, len(found)-1; i < j; i, j = i+1, j-1 {
+		found[i], found[j] = found[j], found[i]
+	}
+	return found, more
+}
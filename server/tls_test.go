@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"path/filepath"
+	"program/certs"
+	"program/election"
+	pb "program/route"
+	"testing"
+	"time"
+)
+
+// reasonOf returns the ErrorReason the server attached to err
+func reasonOf(err error) string {
+	for _, detail := range status.Convert(err).Details() {
+		if info, ok := detail.(*errdetails.ErrorInfo); ok {
+			return info.Reason
+		}
+	}
+	return ""
+}
+
+// tlsServer is a server that requires client certificates from a throwaway CA,
+// listening on a free local port
+type tlsServer struct {
+	dir  string
+	ca   *certs.CA
+	addr string
+}
+
+func startTLSServer(t *testing.T) *tlsServer {
+	t.Helper()
+	dir := t.TempDir()
+	ca, err := certs.NewCA("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := ca.Server("localhost", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	caFile := filepath.Join(dir, "ca.crt")
+	certFile, keyFile := filepath.Join(dir, "server.crt"), filepath.Join(dir, "server.key")
+	if err := ca.Write(caFile, filepath.Join(dir, "ca.key")); err != nil {
+		t.Fatal(err)
+	}
+	if err := leaf.Write(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	config, err := certs.ServerConfig(certFile, keyFile, caFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newServer(time.Now)
+	g := routeServer(s, grpc.Creds(credentials.NewTLS(config)))
+	//Answers the other servers of the election, for the calls between servers
+	election.NewBully(election.Peer{ID: 1, Addr: "localhost:7001"}, nil, time.Second).Register(g)
+	return &tlsServer{dir: dir, ca: ca, addr: serve(t, g)}
+}
+
+// dial connects to the server with a client certificate for name
+func (c *tlsServer) dial(t *testing.T, name string) pb.RouteClient {
+	t.Helper()
+	leaf, err := c.ca.Client(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pb.NewRouteClient(c.connect(t, name, leaf))
+}
+
+// connect connects to the server presenting leaf, saved under name
+func (c *tlsServer) connect(t *testing.T, name string, leaf *certs.Leaf) *grpc.ClientConn {
+	t.Helper()
+	certFile, keyFile := filepath.Join(c.dir, name+".crt"), filepath.Join(c.dir, name+".key")
+	if err := leaf.Write(certFile, keyFile); err != nil {
+		t.Fatal(err)
+	}
+	config, err := certs.ClientConfig(certFile, keyFile, filepath.Join(c.dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dialRoute(t, c.addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+}
+
+func TestCertificateNamesTheClient(t *testing.T) {
+	c := startTLSServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ack, err := c.dial(t, "alice").Connect(ctx, &pb.ConnectRequest{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if ack.Client.Name != "alice" {
+		t.Fatalf("connected as %q, want the name in the certificate", ack.Client.Name)
+	}
+}
+
+func TestCertificateMismatchIsRefused(t *testing.T) {
+	c := startTLSServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	bob := c.dial(t, "bob")
+	_, err := bob.Connect(ctx, &pb.ConnectRequest{Name: "alice"})
+	if reasonOf(err) != pb.ErrorReason_IDENTITY_MISMATCH.String() {
+		t.Fatalf("connecting as alice with bob's certificate: %v", err)
+	}
+
+	//Nor can bob use a session that belongs to alice
+	ack, err := c.dial(t, "alice").Connect(ctx, &pb.ConnectRequest{})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	stolen := metadata.AppendToOutgoingContext(ctx, tokenKey, ack.Token)
+	_, err = bob.Heartbeat(stolen, ack.Client)
+	if reasonOf(err) != pb.ErrorReason_IDENTITY_MISMATCH.String() {
+		t.Fatalf("heartbeat with alice's token and bob's certificate: %v", err)
+	}
+}
+
+func TestClientWithoutCertificateIsRefused(t *testing.T) {
+	c := startTLSServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	config, err := certs.ClientConfig("", "", filepath.Join(c.dir, "ca.crt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := dialRoute(t, c.addr, grpc.WithTransportCredentials(credentials.NewTLS(config)))
+	if _, err := pb.NewRouteClient(conn).Connect(ctx, &pb.ConnectRequest{Name: "alice"}); err == nil {
+		t.Fatal("connected without a client certificate")
+	}
+}
+
+func TestOnlyServersOfTheClusterArePeers(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+	cfg.Peers = "1=localhost:7001,2=localhost:7002"
+
+	c := startTLSServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	alive := func(conn *grpc.ClientConn) error {
+		_, err := pb.NewPeerClient(conn).Alive(ctx, &pb.Ping{From: &pb.PeerInfo{Id: 2, Address: "localhost:7002"}})
+		return err
+	}
+
+	//Chat clients have certificates from the same CA, but not ones a server could use
+	client, err := c.ca.Client("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alive(c.connect(t, "alice", client)); reasonOf(err) != pb.ErrorReason_NOT_A_PEER.String() {
+		t.Fatalf("alive with a client certificate: %v", err)
+	}
+
+	stranger, err := c.ca.Server("evil", "evil.example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alive(c.connect(t, "evil", stranger)); reasonOf(err) != pb.ErrorReason_NOT_A_PEER.String() {
+		t.Fatalf("alive from a server outside the cluster: %v", err)
+	}
+
+	member, err := c.ca.Server("localhost", "localhost")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := alive(c.connect(t, "member", member)); err != nil {
+		t.Fatalf("alive from a server of the cluster: %v", err)
+	}
+}
@@ -0,0 +1,332 @@
+// Package wal is an append-only write-ahead log split over segment files.
+//
+// Every record is stored as a 4 byte little-endian payload length, a 4 byte
+// CRC-32C of the payload and the payload itself. A record torn by a crash at
+// the end of the newest segment is cut off when the log is opened; damage
+// anywhere else is reported as ErrCorrupt.
+package wal
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	headerSize = 8
+	suffix     = ".wal"
+
+	// MaxRecordSize bounds a single payload so a damaged length can't make us allocate gigabytes
+	MaxRecordSize = 16 << 20
+)
+
+// ErrCorrupt is returned when a record fails its checksum or is cut short
+// anywhere but the end of the log.
+var ErrCorrupt = errors.New("wal: corrupt record")
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Log is a write-ahead log in a directory. It is safe for concurrent use.
+type Log struct {
+	mu          sync.Mutex
+	dir         string
+	segmentSize int64
+	segments    []uint64
+	f           *os.File
+	size        int64
+	//Set once a write fails in a way that leaves the end of the log unknown, every append after it fails too
+	failed error
+}
+
+// Open opens or creates the log in dir. A new segment is started once the
+// current one would grow past segmentSize bytes.
+func Open(dir string, segmentSize int64) (*Log, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	segments, err := listSegments(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Log{dir: dir, segmentSize: segmentSize, segments: segments}
+	if len(segments) == 0 {
+		return l, l.startSegment(1)
+	}
+
+	//Cut off whatever a crash left half written at the end
+	last := l.path(segments[len(segments)-1])
+	valid, err := validLength(last)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(last, os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(valid, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	l.f = f
+	l.size = valid
+	return l, nil
+}
+
+// Replay calls fn with every record in the log, oldest first.
+func (l *Log) Replay(fn func(data []byte) error) error {
+	l.mu.Lock()
+	segments := append([]uint64(nil), l.segments...)
+	l.mu.Unlock()
+
+	for _, id := range segments {
+		if err := replaySegment(l.path(id), fn); err != nil {
+			return fmt.Errorf("segment %d: %w", id, err)
+		}
+	}
+	return nil
+}
+
+// Append writes data as one record and syncs it to disk before returning.
+func (l *Log) Append(data []byte) error {
+	if len(data) == 0 || len(data) > MaxRecordSize {
+		return fmt.Errorf("wal: record of %d bytes is not allowed", len(data))
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	if l.failed != nil {
+		return l.failed
+	}
+
+	record := make([]byte, headerSize+len(data))
+	binary.LittleEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.LittleEndian.PutUint32(record[4:8], crc32.Checksum(data, crcTable))
+	copy(record[headerSize:], data)
+
+	if l.size > 0 && l.size+int64(len(record)) > l.segmentSize {
+		if err := l.rotate(); err != nil {
+			return l.fail(err)
+		}
+	}
+	if _, err := l.f.Write(record); err != nil {
+		//Take the torn record back out, records appended after it would be lost at the next Open
+		if terr := l.f.Truncate(l.size); terr != nil {
+			return l.fail(err)
+		}
+		if _, serr := l.f.Seek(l.size, io.SeekStart); serr != nil {
+			return l.fail(err)
+		}
+		return err
+	}
+	//After a failed sync nobody can tell what reached the disk
+	if err := l.f.Sync(); err != nil {
+		return l.fail(err)
+	}
+	l.size += int64(len(record))
+	return nil
+}
+
+// fail stops the log from taking any more records. Call with mu held.
+func (l *Log) fail(err error) error {
+	l.failed = fmt.Errorf("wal: log failed, reopen it: %w", err)
+	return l.failed
+}
+
+// Sync flushes the current segment to disk.
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return os.ErrClosed
+	}
+	return l.f.Sync()
+}
+
+// Close syncs and closes the log.
+func (l *Log) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return nil
+	}
+	err := l.f.Sync()
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+func (l *Log) rotate() error {
+	if err := l.f.Sync(); err != nil {
+		return err
+	}
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+	return l.startSegment(l.segments[len(l.segments)-1] + 1)
+}
+
+func (l *Log) startSegment(id uint64) error {
+	f, err := os.OpenFile(l.path(id), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := syncDir(l.dir); err != nil {
+		f.Close()
+		return err
+	}
+	l.f = f
+	l.size = 0
+	l.segments = append(l.segments, id)
+	return nil
+}
+
+func (l *Log) path(id uint64) string {
+	return filepath.Join(l.dir, fmt.Sprintf("%016d%s", id, suffix))
+}
+
+func listSegments(dir string) ([]uint64, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []uint64
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, suffix) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, suffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids, nil
+}
+
+func replaySegment(path string, fn func(data []byte) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	for {
+		data, err := readRecord(r)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+}
+
+// validLength returns how many bytes at the start of the segment hold whole, intact
+// records. Only the last record may be damaged, as a crash in the middle of writing
+// it leaves it; damage with more of the segment after it is ErrCorrupt.
+func validLength(path string) (int64, error) {
+	segment, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+
+	var valid int64
+	r := bytes.NewReader(segment)
+	for {
+		data, err := readRecord(r)
+		switch {
+		case err == io.EOF:
+			return valid, nil
+		case errors.Is(err, ErrCorrupt):
+			if tornTail(segment[valid:]) {
+				return valid, nil
+			}
+			return 0, fmt.Errorf("%w at offset %d of %s", err, valid, filepath.Base(path))
+		case err != nil:
+			return 0, err
+		}
+		valid += int64(headerSize + len(data))
+	}
+}
+
+// tornTail reports whether the damaged bytes at the end of a segment are what an
+// interrupted append leaves: part of one record and nothing after it, or space
+// the file system allocated but never got to write
+func tornTail(rest []byte) bool {
+	if len(rest) < headerSize {
+		return true
+	}
+	length := binary.LittleEndian.Uint32(rest[0:4])
+	if length == 0 {
+		for _, b := range rest {
+			if b != 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return length <= MaxRecordSize && int64(headerSize)+int64(length) >= int64(len(rest))
+}
+
+// readRecord returns io.EOF at a clean end of the segment and ErrCorrupt for anything damaged
+func readRecord(r io.Reader) ([]byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: short header", ErrCorrupt)
+		}
+		return nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	//Empty records are never written, so zeroes here are unwritten space left by a crash
+	if length == 0 || length > MaxRecordSize {
+		return nil, fmt.Errorf("%w: length %d", ErrCorrupt, length)
+	}
+	data := make([]byte, length)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("%w: short payload", ErrCorrupt)
+		}
+		return nil, err
+	}
+	if crc32.Checksum(data, crcTable) != binary.LittleEndian.Uint32(header[4:8]) {
+		return nil, fmt.Errorf("%w: checksum mismatch", ErrCorrupt)
+	}
+	return data, nil
+}
+
+// syncDir makes a newly created segment file survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,160 @@
+package wal
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func appendAll(t *testing.T, l *Log, records ...string) {
+	t.Helper()
+	for _, r := range records {
+		if err := l.Append([]byte(r)); err != nil {
+			t.Fatalf("append %q: %v", r, err)
+		}
+	}
+}
+
+func replayAll(t *testing.T, l *Log) []string {
+	t.Helper()
+	var got []string
+	if err := l.Replay(func(data []byte) error {
+		got = append(got, string(data))
+		return nil
+	}); err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	return got
+}
+
+// segmentPath returns the only segment in dir
+func segmentPath(t *testing.T, dir string) string {
+	t.Helper()
+	matches, err := filepath.Glob(filepath.Join(dir, "*"+suffix))
+	if err != nil || len(matches) != 1 {
+		t.Fatalf("segments = %v, %v", matches, err)
+	}
+	return matches[0]
+}
+
+func TestTornTailIsCutOff(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAll(t, l, "one", "two")
+	l.Close()
+
+	//A crash halfway through the third record
+	f, err := os.OpenFile(segmentPath(t, dir), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{5, 0, 0, 0, 1, 2, 3, 4, 't', 'h'})
+	f.Close()
+
+	l, err = Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("open after a torn append: %v", err)
+	}
+	defer l.Close()
+	appendAll(t, l, "three")
+	if got, want := replayAll(t, l), []string{"one", "two", "three"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %q, want %q", got, want)
+	}
+}
+
+func TestUnwrittenSpaceIsCutOff(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAll(t, l, "one")
+	l.Close()
+
+	f, err := os.OpenFile(segmentPath(t, dir), os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write(make([]byte, 64))
+	f.Close()
+
+	l, err = Open(dir, 1<<20)
+	if err != nil {
+		t.Fatalf("open with zeroes at the end: %v", err)
+	}
+	defer l.Close()
+	if got := replayAll(t, l); !reflect.DeepEqual(got, []string{"one"}) {
+		t.Fatalf("replayed %q", got)
+	}
+}
+
+func TestCorruptionBeforeTheTailIsReported(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAll(t, l, "one", "two", "three")
+	l.Close()
+
+	//Flip a byte of the second record's payload, the third is still whole after it
+	path := segmentPath(t, dir)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[headerSize+len("one")+headerSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Open(dir, 1<<20); !errors.Is(err, ErrCorrupt) {
+		t.Fatalf("open = %v, want ErrCorrupt", err)
+	}
+	//Nothing was cut off
+	if after, _ := os.ReadFile(path); len(after) != len(data) {
+		t.Fatalf("segment shrank from %d to %d bytes", len(data), len(after))
+	}
+}
+
+func TestRecordsSurviveRotation(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(dir, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAll(t, l, "first record", "second record", "third record")
+	l.Close()
+
+	l, err = Open(dir, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if got, want := replayAll(t, l), []string{"first record", "second record", "third record"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("replayed %q, want %q", got, want)
+	}
+}
+
+func TestFailedLogRefusesAppends(t *testing.T) {
+	l, err := Open(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	appendAll(t, l, "one")
+
+	//Closing the file under the log makes the next write fail
+	l.f.Close()
+	if err := l.Append([]byte("two")); err == nil {
+		t.Fatal("append to a closed file succeeded")
+	}
+	if err := l.Append([]byte("three")); err == nil {
+		t.Fatal("append after a failed one succeeded")
+	}
+}